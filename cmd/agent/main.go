@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/agent"
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/logging"
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/tracing"
+)
+
+func main() {
+	logging.Setup()
+
+	config := agent.ConfigFromEnv()
+	slog.Info("starting agent", "agent_id", config.ID, "orchestrator", config.OrchestratorURL)
+	shutdownTracing, err := tracing.Setup(context.Background(), "agent")
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	// По сигналу агент перестаёт брать задачи и дожидается текущих
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	agent.Start(ctx, config)
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+}
@@ -0,0 +1,83 @@
+// calc-cli – отправка выражения на оркестратор и ожидание результата:
+//
+//	calc-cli [--url http://localhost:8080] [--timeout 1m] [--json] "2 + 2 * 2"
+//
+// Ключ API берётся из переменной окружения API_KEY
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/client"
+)
+
+// pollInterval – пауза между запросами состояния выражения
+const pollInterval = 200 * time.Millisecond
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "orchestrator address")
+	timeout := flag.Duration("timeout", time.Minute, "how long to wait for the result")
+	asJSON := flag.Bool("json", false, "print the whole expression as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] \"expression\"\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	c := client.New(*baseURL, 0)
+	c.APIKey = os.Getenv("API_KEY")
+	expr, err := calculate(ctx, c, flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(expr)
+	} else if expr.Status == "completed" && expr.Result != nil {
+		fmt.Println(*expr.Result)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", expr.Status, expr.Error)
+	}
+	if expr.Status != "completed" {
+		os.Exit(1)
+	}
+}
+
+// calculate – отправка выражения и опрос, пока оно не завершится
+func calculate(ctx context.Context, c *client.Client, expression string) (client.Expression, error) {
+	id, err := c.Calculate(ctx, expression)
+	if err != nil {
+		return client.Expression{}, err
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		expr, err := c.GetExpression(ctx, id)
+		if err != nil {
+			return client.Expression{}, err
+		}
+		if expr.Status != "pending" && expr.Status != "processing" {
+			return expr, nil
+		}
+		select {
+		case <-ctx.Done():
+			return client.Expression{}, fmt.Errorf("expression %s is still %s: %w", id, expr.Status, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
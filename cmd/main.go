@@ -1,11 +0,0 @@
-package main
-
-import (
-	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/application"
-)
-
-func main() {
-	app := application.New()
-	app.RunServer()
-
-}
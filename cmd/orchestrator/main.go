@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/application"
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/logging"
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/tracing"
+)
+
+func main() {
+	logging.Setup()
+
+	config, err := application.ConfigFromFlags(os.Args[1:])
+	if err != nil {
+		slog.Error("ошибка в конфигурации", "error", err)
+		os.Exit(2)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), "orchestrator")
+	if err != nil {
+		slog.Error("ошибка при настройке трассировки", "error", err)
+		os.Exit(1)
+	}
+	app, err := application.NewWithConfig(config)
+	if err != nil {
+		slog.Error("ошибка при запуске приложения", "error", err)
+		os.Exit(1)
+	}
+	err = app.RunServer()
+	if closeErr := app.Close(); closeErr != nil {
+		slog.Error("ошибка при закрытии хранилища", "error", closeErr)
+	}
+	if traceErr := shutdownTracing(context.Background()); traceErr != nil {
+		slog.Error("ошибка при отправке трасс", "error", traceErr)
+	}
+	if err != nil {
+		slog.Error("сервер завершился с ошибкой", "error", err)
+		os.Exit(1)
+	}
+}
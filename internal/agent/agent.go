@@ -2,141 +2,244 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Task – задача от оркестратора. parent – контекст трассировки, в котором
+// оркестратор поставил задачу (из заголовка traceparent ответа)
 type Task struct {
-	ID        string  `json:"id"`
-	Arg1      float64 `json:"arg1"`
-	Arg2      float64 `json:"arg2"`
-	Operation string  `json:"operation"`
+	ID            string  `json:"id"`
+	Arg1          float64 `json:"arg1"`
+	Arg2          float64 `json:"arg2"`
+	Operation     string  `json:"operation"`
+	OperationTime int64   `json:"operation_time"`
+
+	parent trace.SpanContext
 }
 
 type Result struct {
 	ID     string  `json:"id"`
 	Result float64 `json:"result"`
+	Error  string  `json:"error,omitempty"`
 }
 
-func Start() {
-	for {
+// Start – цикл получения и вычисления задач оркестратора config.OrchestratorURL.
+// Если задан GRPCAddr, задачи приходят по gRPC-стриму. После отмены ctx новые
+// задачи не берутся, а Start возвращается, когда уже полученные задачи
+// посчитаны и их результаты отправлены
+func Start(ctx context.Context, config Config) {
+	go keepAlive(ctx, config)
+	if config.GRPCAddr != "" {
+		startGRPC(ctx, config)
+		return
+	}
+
+	// Результаты отправляются и после отмены ctx – иначе посчитанное потеряется
+	sendCtx := context.WithoutCancel(ctx)
+	var running sync.WaitGroup
+	for ctx.Err() == nil {
 		// Получаем задачу от оркестратора
-		task, found := getTask()
-		if !found {
-			log.Println("No task available, waiting...")
-			time.Sleep(2 * time.Second)
+		// Оркестратор сам ждёт появления задачи, поэтому пауза нужна только после сбоя
+		task, err := getTask(ctx, config)
+		if errors.Is(err, errNoTask) || err != nil && ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			slog.Warn("failed to get task", "error", err)
+			sleep(ctx, 2*time.Second)
 			continue
 		}
 
-		// Запускаем горутину для обработки каждой задачи
+		// Запускаем горутину для обработки каждой задачи; уже полученная
+		// задача считается, даже если ctx отменили во время запроса
+		running.Add(1)
 		go func(task Task) {
-			// Выполняем вычисление задачи
-			result, err := performCalculation(task)
-			if err != nil {
-				log.Println("Error performing calculation:", err)
-				return
+			defer running.Done()
+			taskCtx, span := startTaskSpan(sendCtx, task)
+			defer span.End()
+			res := process(task)
+			recordTaskResult(span, res)
+			// Отправляем результат обратно в оркестратор, статус выражения обновляет он
+			if err := sendResult(taskCtx, config, res); err != nil {
+				slog.Error("error sending result", "task_id", task.ID, "error", err)
 			}
+		}(task)
+	}
 
-			// Отправляем результат обратно в оркестратор
-			err = sendResult(task.ID, result)
-			if err != nil {
-				log.Println("Error sending result:", err)
-			}
+	slog.Info("agent is stopping, waiting for running tasks")
+	running.Wait()
+	slog.Info("agent stopped")
+}
 
-			// Обновляем статус выражения
-			expressions[task.ID].Status = "completed"
-			expressions[task.ID].Result = result
-		}(task)
+// sleep – пауза на d, прерываемая отменой ctx
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
 
-		time.Sleep(2 * time.Second) // Задержка между задачами
+// process – вычисление задачи с эмуляцией её длительности; ошибку тоже
+// сообщаем оркестратору
+func process(task Task) Result {
+	res := Result{ID: task.ID}
+	result, err := performCalculation(task)
+	if err != nil {
+		slog.Warn("error performing calculation", "task_id", task.ID, "operation", task.Operation, "error", err)
+		res.Error = err.Error()
+	} else {
+		res.Result = result
 	}
+
+	// Эмулируем длительность операции
+	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+	return res
 }
 
-func getTask() (Task, error) {
-	var task Task
-	var err error
+// taskWait – сколько оркестратор ждёт задачу, прежде чем ответить 404
+const taskWait = 30 * time.Second
+
+// errNoTask – у оркестратора нет задач
+var errNoTask = fmt.Errorf("no task available: %w", errPermanent)
 
-	for attempts := 0; attempts < 3; attempts++ {
-		resp, err := http.Get("http://localhost:8080/internal/task")
+// taskQuery – параметры запроса задачи: сколько её ждать и какие операции агент берёт
+func taskQuery(config Config) url.Values {
+	query := url.Values{"wait": {taskWait.String()}}
+	if len(config.Operations) > 0 {
+		query.Set("ops", strings.Join(config.Operations, ","))
+	}
+	return query
+}
+
+// getTask – получение задачи; сетевые ошибки и ответы 5xx повторяются.
+// Отмена ctx прерывает ожидание задачи, но пришедший ответ дочитывается:
+// выданную задачу оркестратор уже считает занятой
+func getTask(ctx context.Context, config Config) (Task, error) {
+	// Оркестратор держит запрос до taskWait, пока задач нет, – это не зависание
+	if config.HTTPTimeout > 0 {
+		config.HTTPTimeout += taskWait
+	}
+	var task Task
+	err := withRetry(ctx, config.Retry, "get task", func() error {
+		reqCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancel()
+		stop := context.AfterFunc(ctx, cancel)
+		resp, err := do(reqCtx, config, http.MethodGet, "/internal/task?"+taskQuery(config).Encode(), nil)
+		stop()
 		if err != nil {
-			log.Printf("Error sending GET request to /internal/task: %v", err)
-			time.Sleep(2 * time.Second)
-			continue
+			return err
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Failed to get task. HTTP status code: %d", resp.StatusCode)
-			time.Sleep(2 * time.Second)
-			continue
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return errNoTask
+		case resp.StatusCode >= 500:
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		case resp.StatusCode != http.StatusOK:
+			return fmt.Errorf("unexpected status %d: %w", resp.StatusCode, errPermanent)
 		}
-
 		if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
-			log.Printf("Error decoding response body: %v", err)
-			time.Sleep(2 * time.Second)
-			continue
+			return fmt.Errorf("error decoding response body: %w", err)
 		}
-
-		log.Printf("Successfully received task: %v", task)
-		return task, nil
+		task.parent = taskParent(resp.Header)
+		return nil
+	})
+	if err != nil {
+		return Task{}, err
 	}
 
-	return task, fmt.Errorf("failed to get task after 3 attempts: %v", err)
+	slog.Info("received task", "task_id", task.ID, "operation", task.Operation)
+	return task, nil
 }
 
-func performCalculation(task Task) (float64, error) {
-	// Проверка корректности аргументов (если нужно)
-	if task.Arg1 == 0 || task.Arg2 == 0 {
-		return 0, fmt.Errorf("invalid arguments, task.Arg1 and task.Arg2 must not be zero")
-	}
+// transport – общий транспорт запросов к оркестратору: клиенты с разными
+// таймаутами переиспользуют одни и те же соединения. Агент шлёт результаты
+// параллельно, поэтому свободных соединений держим больше стандартных двух
+var transport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = 16
+	return t
+}()
+
+// httpClient – клиент запросов к оркестратору с таймаутом config.HTTPTimeout
+func httpClient(config Config) *http.Client {
+	return &http.Client{Transport: transport, Timeout: config.HTTPTimeout}
+}
 
-	// Формируем строку выражения для вычислений
-	expression := fmt.Sprintf("%f %s %f", task.Arg1, task.Operation, task.Arg2)
+// do – запрос к оркестратору по пути path; если задан InternalKey, он
+// передаётся в заголовке Authorization, контекст трассировки ctx – в traceparent
+func do(ctx context.Context, config Config, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, config.OrchestratorURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if config.InternalKey != "" {
+		req.Header.Set("Authorization", "Bearer "+config.InternalKey)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return httpClient(config).Do(req)
+}
 
-	// Используем функцию Calc из пакета calculation для вычислений
-	result, err := calculation.Calc(expression)
+func performCalculation(task Task) (float64, error) {
+	// Нулевые аргументы допустимы; деление на ноль Apply отвергает сам
+	result, err := calculation.Apply(task.Operation, task.Arg1, task.Arg2)
 	if err != nil {
-		return 0, fmt.Errorf("error calculating expression: %v", err)
+		return 0, fmt.Errorf("error calculating expression: %w", err)
 	}
 
 	return result, nil
 }
 
-func sendResult(taskID string, result float64) error {
-	resultData := Result{
-		ID:     taskID,
-		Result: result,
-	}
-
+// sendResult – отправка результата. Потерять посчитанный результат хуже всего,
+// поэтому сетевые ошибки и ответы 5xx повторяются до исчерпания попыток
+func sendResult(ctx context.Context, config Config, resultData Result) error {
 	data, err := json.Marshal(resultData)
 	if err != nil {
-		log.Printf("Error marshalling result data: %v\n", err)
+		slog.Error("error marshalling result data", "task_id", resultData.ID, "error", err)
 		return err
 	}
 
-	for attempts := 0; attempts < 3; attempts++ {
-		resp, err := http.Post("http://localhost:8080/internal/task", "application/json", bytes.NewBuffer(data))
+	err = withRetry(ctx, config.Retry, "send result", func() error {
+		resp, err := do(ctx, config, http.MethodPost, "/internal/task", data)
 		if err != nil {
-			log.Printf("Error sending result to server: %v\n", err)
-			time.Sleep(2 * time.Second)
-			continue
+			return err
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Failed to send result, received status code: %d\n", resp.StatusCode)
-			time.Sleep(2 * time.Second)
-			continue
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return nil
+		case resp.StatusCode >= 500:
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		default:
+			// Например, 404 для задачи отменённого выражения
+			return fmt.Errorf("unexpected status %d: %w", resp.StatusCode, errPermanent)
 		}
-
-		log.Printf("Successfully sent result for task %s, received status: %d\n", taskID, resp.StatusCode)
-		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
 	}
 
-	return fmt.Errorf("failed to send result after 3 attempts")
+	slog.Info("sent result", "task_id", resultData.ID)
+	return nil
 }
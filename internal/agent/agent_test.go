@@ -0,0 +1,295 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/agent"
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/application"
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/taskpb"
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestPerformCalculation(t *testing.T) {
+	tests := []struct {
+		name     string
+		task     agent.Task
+		expected float64
+	}{
+		{"zero plus zero", agent.Task{Arg1: 0, Arg2: 0, Operation: "+"}, 0},
+		{"multiply by zero", agent.Task{Arg1: 5, Arg2: 0, Operation: "*"}, 0},
+		{"zero minus", agent.Task{Arg1: 0, Arg2: 7, Operation: "-"}, -7},
+		{"zero divided", agent.Task{Arg1: 0, Arg2: 4, Operation: "/"}, 0},
+		{"fraction precision", agent.Task{Arg1: 0.1234567, Arg2: 1, Operation: "*"}, 0.1234567},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := agent.PerformCalculation(test.task)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+
+	_, err := agent.PerformCalculation(agent.Task{Arg1: 5, Arg2: 0, Operation: "/"})
+	if !errors.Is(err, calculation.ErrDivisionByZero) {
+		t.Fatalf("expected division by zero, got %v", err)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	config := agent.RetryConfig{Attempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	// Временные ошибки повторяются до успеха
+	calls := 0
+	err := agent.WithRetry(context.Background(), config, "test", func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expected success on 3rd call, got %v after %d calls", err, calls)
+	}
+
+	// Ошибка возвращается только после исчерпания попыток
+	calls = 0
+	failure := errors.New("connection refused")
+	err = agent.WithRetry(context.Background(), config, "test", func() error {
+		calls++
+		return failure
+	})
+	if !errors.Is(err, failure) || calls != 4 {
+		t.Fatalf("expected last error after 4 calls, got %v after %d calls", err, calls)
+	}
+
+	// Постоянная ошибка не повторяется
+	calls = 0
+	err = agent.WithRetry(context.Background(), config, "test", func() error {
+		calls++
+		return fmt.Errorf("status 404: %w", agent.ErrPermanent)
+	})
+	if !errors.Is(err, agent.ErrPermanent) || calls != 1 {
+		t.Fatalf("expected permanent error after 1 call, got %v after %d calls", err, calls)
+	}
+}
+
+func TestOrchestratorURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/internal/task" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"id":"t1","arg1":2,"arg2":3,"operation":"+"}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("ORCHESTRATOR_URL", srv.URL+"/")
+	t.Setenv("INTERNAL_KEY", "secret")
+	config := agent.ConfigFromEnv()
+	if config.OrchestratorURL != srv.URL || config.ID == "" {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+
+	task, err := agent.GetTask(context.Background(), config)
+	if err != nil || task.ID != "t1" || task.Arg1 != 2 {
+		t.Fatalf("unexpected task %+v, error %v", task, err)
+	}
+
+	t.Setenv("ORCHESTRATOR_URL", "")
+	if config := agent.ConfigFromEnv(); config.OrchestratorURL != "http://localhost:8080" {
+		t.Fatalf("expected localhost by default, got %q", config.OrchestratorURL)
+	}
+}
+
+func TestAgentOperations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ops := r.URL.Query().Get("ops"); ops != "+,-" {
+			t.Errorf("expected ops=+,-, got %q", ops)
+		}
+		w.Write([]byte(`{"id":"t1","arg1":2,"arg2":3,"operation":"+"}`))
+	}))
+	defer srv.Close()
+
+	t.Setenv("ORCHESTRATOR_URL", srv.URL)
+	t.Setenv("AGENT_OPS", "+, -,")
+	config := agent.ConfigFromEnv()
+	if !slices.Equal(config.Operations, []string{"+", "-"}) {
+		t.Fatalf("unexpected operations %q", config.Operations)
+	}
+	if _, err := agent.GetTask(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AGENT_OPS", "")
+	if config := agent.ConfigFromEnv(); config.Operations != nil {
+		t.Fatalf("expected any operation by default, got %q", config.Operations)
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			// Оркестратор ждёт задачу дольше таймаута – это не зависание
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(`{"id":"t1","arg1":2,"arg2":3,"operation":"+"}`))
+			return
+		}
+		// Зависший оркестратор не отвечает на результат
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	t.Setenv("ORCHESTRATOR_URL", srv.URL)
+	t.Setenv("HTTP_CLIENT_TIMEOUT", "50ms")
+	config := agent.ConfigFromEnv()
+	if config.HTTPTimeout != 50*time.Millisecond {
+		t.Fatalf("expected 50ms timeout, got %v", config.HTTPTimeout)
+	}
+	config.Retry = agent.RetryConfig{Attempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	start := time.Now()
+	err := agent.SendResult(context.Background(), config, agent.Result{ID: "t1", Result: 5})
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected both attempts to time out quickly, took %v", elapsed)
+	}
+
+	if task, err := agent.GetTask(context.Background(), config); err != nil || task.ID != "t1" {
+		t.Fatalf("expected long poll to outlive the timeout, got %+v, %v", task, err)
+	}
+
+	t.Setenv("HTTP_CLIENT_TIMEOUT", "")
+	if config := agent.ConfigFromEnv(); config.HTTPTimeout != 10*time.Second {
+		t.Fatalf("expected 10s timeout by default, got %v", config.HTTPTimeout)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	// taken – агент взял задачу в работу и пришёл за следующей
+	taken := make(chan struct{})
+	sent := make(chan agent.Result, 1)
+	var served atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/internal/task" && r.Method == http.MethodGet:
+			// Первая задача считается долго, дальше задач нет
+			if n := served.Add(1); n > 1 {
+				if n == 2 {
+					close(taken)
+				}
+				<-r.Context().Done()
+				return
+			}
+			w.Write([]byte(`{"id":"t1","arg1":2,"arg2":3,"operation":"*","operation_time":200}`))
+		case r.URL.Path == "/internal/task":
+			var res agent.Result
+			json.NewDecoder(r.Body).Decode(&res)
+			sent <- res
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		agent.Start(ctx, agent.Config{OrchestratorURL: srv.URL, ID: "a1", Retry: agent.RetryConfig{Attempts: 1}})
+		close(stopped)
+	}()
+
+	<-taken
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not stop")
+	}
+	// Start возвращается только после отправки результата текущей задачи
+	select {
+	case res := <-sent:
+		if res.ID != "t1" || res.Result != 6 {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	default:
+		t.Fatal("result of the running task was not sent")
+	}
+	if n := served.Load(); n > 2 {
+		t.Fatalf("agent kept taking tasks after shutdown: %d requests", n)
+	}
+}
+
+func TestGRPCAgent(t *testing.T) {
+	t.Setenv("DB_PATH", "")
+	t.Setenv("COMPUTING_POWER", "0")
+	app, err := application.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+	router := app.Handler()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := app.GRPCServer()
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.ProcessStream(ctx, agent.Config{ComputingPower: 2}, taskpb.NewTaskServiceClient(conn))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression":"2 + 3 * 4"}`)))
+	var created struct{ ID string }
+	json.NewDecoder(w.Body).Decode(&created)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions/"+created.ID, nil))
+		var expr application.Expression
+		json.NewDecoder(w.Body).Decode(&expr)
+		if expr.Status == "completed" {
+			if expr.Result != 14 {
+				t.Fatalf("expected 14, got %v", expr.Result)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expression is still %q", expr.Status)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultOrchestratorURL – адрес оркестратора, запущенного на той же машине
+const defaultOrchestratorURL = "http://localhost:8080"
+
+// defaultHTTPTimeout – предел запроса к оркестратору по умолчанию
+const defaultHTTPTimeout = 10 * time.Second
+
+// Config – конфигурация агента
+type Config struct {
+	// OrchestratorURL – базовый адрес оркестратора, без завершающего "/"
+	OrchestratorURL string
+	// GRPCAddr – адрес gRPC-сервера оркестратора ("host:port"); пустой –
+	// задачи берутся через HTTP
+	GRPCAddr string
+	// ID – под каким ID агент регистрируется в оркестраторе
+	ID string
+	// ComputingPower – сколько задач агент сообщает, что может считать одновременно
+	ComputingPower int
+	// Operations – какие операции агент берёт (например, "+" и "-"), чтобы
+	// быстрые задачи не ждали за долгими; пусто – любые
+	Operations []string
+	// InternalKey – ключ для заголовка Authorization; пустой – без авторизации
+	InternalKey string
+	// HTTPTimeout – предел запроса к оркестратору по HTTP вместе с чтением
+	// ответа, чтобы зависший оркестратор не подвесил агента; 0 – без предела
+	HTTPTimeout time.Duration
+	Retry       RetryConfig
+}
+
+// ConfigFromEnv – конфигурация из ORCHESTRATOR_URL, ORCHESTRATOR_GRPC_ADDR, AGENT_ID, COMPUTING_POWER,
+// AGENT_OPS (операции через запятую), INTERNAL_KEY, HTTP_CLIENT_TIMEOUT (по
+// умолчанию 10s) и параметров повторов. Без AGENT_ID ID генерируется при запуске
+func ConfigFromEnv() Config {
+	config := Config{
+		OrchestratorURL: strings.TrimRight(os.Getenv("ORCHESTRATOR_URL"), "/"),
+		GRPCAddr:        os.Getenv("ORCHESTRATOR_GRPC_ADDR"),
+		ID:              os.Getenv("AGENT_ID"),
+		ComputingPower:  int(intFromEnv("COMPUTING_POWER", 1)),
+		Operations:      operationsFromEnv("AGENT_OPS"),
+		InternalKey:     os.Getenv("INTERNAL_KEY"),
+		HTTPTimeout:     durationFromEnv("HTTP_CLIENT_TIMEOUT", defaultHTTPTimeout),
+		Retry:           RetryConfigFromEnv(),
+	}
+	if config.OrchestratorURL == "" {
+		config.OrchestratorURL = defaultOrchestratorURL
+	}
+	if config.ID == "" {
+		config.ID = uuid.New().String()
+	}
+	return config
+}
+
+// operationsFromEnv – список операций через запятую; пустые элементы пропускаются
+func operationsFromEnv(name string) []string {
+	var ops []string
+	for _, op := range strings.Split(os.Getenv(name), ",") {
+		if op = strings.TrimSpace(op); op != "" {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
@@ -0,0 +1,19 @@
+package agent
+
+// PerformCalculation – вычисление задачи для тестов
+var PerformCalculation = performCalculation
+
+// WithRetry – выполнение с повторами для тестов
+var WithRetry = withRetry
+
+// ErrPermanent – пометка ошибки, которую не нужно повторять
+var ErrPermanent = errPermanent
+
+// GetTask – получение задачи от оркестратора для тестов
+var GetTask = getTask
+
+// ProcessStream – обмен задачами по gRPC-стриму для тестов
+var ProcessStream = processStream
+
+// SendResult – отправка результата оркестратору для тестов
+var SendResult = sendResult
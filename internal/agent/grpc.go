@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/taskpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// startGRPC – получение задач по gRPC-стриму до отмены ctx; после обрыва
+// стрим открывается заново
+func startGRPC(ctx context.Context, config Config) {
+	conn, err := grpc.NewClient(config.GRPCAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		slog.Error("invalid orchestrator gRPC address", "address", config.GRPCAddr, "error", err)
+		return
+	}
+	defer conn.Close()
+	client := taskpb.NewTaskServiceClient(conn)
+
+	for {
+		err := processStream(ctx, config, client)
+		if ctx.Err() != nil {
+			slog.Info("agent stopped")
+			return
+		}
+		slog.Warn("task stream closed", "error", err)
+		sleep(ctx, 2*time.Second)
+	}
+}
+
+// processStream – обмен задачами и результатами в одном стриме, пока он жив.
+// Оркестратор присылает не больше ComputingPower задач сразу. После отмены ctx
+// новые задачи не берутся, но стрим закрывается только после отправки
+// результатов уже полученных; пришедшие позже оркестратор выдаст снова по
+// истечении аренды
+func processStream(ctx context.Context, config Config, client taskpb.TaskServiceClient) error {
+	streamCtx := metadata.AppendToOutgoingContext(context.WithoutCancel(ctx), "computing-power", strconv.Itoa(max(config.ComputingPower, 1)))
+	if config.InternalKey != "" {
+		streamCtx = metadata.AppendToOutgoingContext(streamCtx, "authorization", "Bearer "+config.InternalKey)
+	}
+	if len(config.Operations) > 0 {
+		streamCtx = metadata.AppendToOutgoingContext(streamCtx, "operations", strings.Join(config.Operations, ","))
+	}
+	streamCtx, cancel := context.WithCancel(streamCtx)
+	defer cancel()
+	stream, err := client.Process(streamCtx)
+	if err != nil {
+		return err
+	}
+
+	// Recv блокируется, поэтому читает стрим отдельная горутина
+	type received struct {
+		pb  *taskpb.Task
+		err error
+	}
+	incoming := make(chan received)
+	go func() {
+		for {
+			pb, err := stream.Recv()
+			select {
+			case incoming <- received{pb, err}:
+			case <-streamCtx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Send стрима нельзя вызывать из нескольких горутин одновременно
+	var sendMu sync.Mutex
+	var running sync.WaitGroup
+	defer running.Wait()
+	for {
+		var in received
+		select {
+		case <-ctx.Done():
+			return nil
+		case in = <-incoming:
+		}
+		pb, err := in.pb, in.err
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		task := Task{ID: pb.GetId(), Arg1: pb.GetArg1(), Arg2: pb.GetArg2(), Operation: pb.GetOperation(), OperationTime: pb.GetOperationTime()}
+		slog.Info("received task", "task_id", task.ID, "operation", task.Operation)
+
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			// Контекст трассировки по gRPC не передаётся, span агента начинает свою трассу
+			_, span := startTaskSpan(streamCtx, task)
+			defer span.End()
+			res := process(task)
+			recordTaskResult(span, res)
+			sendMu.Lock()
+			err := stream.Send(&taskpb.Result{Id: res.ID, Result: res.Result, Error: res.Error})
+			sendMu.Unlock()
+			if err != nil {
+				// Результат потерян вместе со стримом; оркестратор выдаст задачу снова
+				slog.Error("error sending result", "task_id", task.ID, "error", err)
+				return
+			}
+			slog.Info("sent result", "task_id", task.ID)
+		}()
+	}
+}
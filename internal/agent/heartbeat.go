@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// heartbeatInterval – как часто агент сообщает оркестратору, что жив
+const heartbeatInterval = 10 * time.Second
+
+// register – регистрация агента в оркестраторе
+func register(ctx context.Context, config Config) error {
+	data, err := json.Marshal(map[string]interface{}{"id": config.ID, "computing_power": config.ComputingPower, "operations": config.Operations})
+	if err != nil {
+		return err
+	}
+	resp, err := do(ctx, config, http.MethodPost, "/internal/register", data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// heartbeat – сигнал живости; 404 значит, что оркестратор нас не знает
+// (например, после перезапуска), и агент регистрируется заново
+func heartbeat(ctx context.Context, config Config) error {
+	data, err := json.Marshal(map[string]string{"id": config.ID})
+	if err != nil {
+		return err
+	}
+	resp, err := do(ctx, config, http.MethodPost, "/internal/heartbeat", data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return register(ctx, config)
+	default:
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+// keepAlive – регистрация и периодический heartbeat до отмены ctx. Ошибки
+// только логируются: задачи агент берёт и без регистрации
+func keepAlive(ctx context.Context, config Config) {
+	if err := register(ctx, config); err != nil {
+		slog.Warn("failed to register agent", "agent_id", config.ID, "error", err)
+	} else {
+		slog.Info("agent registered", "agent_id", config.ID, "computing_power", config.ComputingPower, "operations", config.Operations)
+	}
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := heartbeat(ctx, config); err != nil {
+			slog.Warn("failed to send heartbeat", "agent_id", config.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// RetryConfig – параметры повторов запросов к оркестратору: до Attempts
+// попыток, пауза растёт от BaseDelay вдвое с каждой попыткой, но не больше MaxDelay
+type RetryConfig struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// RetryConfigFromEnv – параметры повторов из AGENT_RETRY_ATTEMPTS,
+// AGENT_RETRY_BASE_MS и AGENT_RETRY_MAX_MS
+func RetryConfigFromEnv() RetryConfig {
+	return RetryConfig{
+		Attempts:  int(intFromEnv("AGENT_RETRY_ATTEMPTS", 5)),
+		BaseDelay: time.Duration(intFromEnv("AGENT_RETRY_BASE_MS", 200)) * time.Millisecond,
+		MaxDelay:  time.Duration(intFromEnv("AGENT_RETRY_MAX_MS", 10000)) * time.Millisecond,
+	}
+}
+
+// errPermanent – повтор не поможет (например, оркестратор ответил 4xx)
+var errPermanent = errors.New("permanent error")
+
+// backoff – пауза перед повтором номер attempt (с нуля): экспонента с
+// джиттером в диапазоне [d/2, d], чтобы агенты не стучались одновременно
+func (c RetryConfig) backoff(attempt int) time.Duration {
+	d := c.BaseDelay << attempt
+	if d <= 0 || d > c.MaxDelay {
+		d = c.MaxDelay
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// withRetry – выполнение op с повторами. Ошибка возвращается наружу только
+// после исчерпания попыток, если она помечена errPermanent или если ctx отменён
+func withRetry(ctx context.Context, c RetryConfig, name string, op func() error) error {
+	var err error
+	for attempt := 0; attempt < max(c.Attempts, 1); attempt++ {
+		if attempt > 0 {
+			delay := c.backoff(attempt - 1)
+			slog.Debug("retrying request", "request", name, "attempt", attempt+1, "delay", delay, "error", err)
+			sleep(ctx, delay)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err = op(); err == nil || errors.Is(err, errPermanent) {
+			return err
+		}
+	}
+	return err
+}
+
+// durationFromEnv – чтение положительной длительности ("10s", "500ms") из
+// переменной окружения
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("invalid environment variable, using default", "name", name, "value", value, "default", def)
+		return def
+	}
+	return d
+}
+
+// intFromEnv – чтение неотрицательного целого из переменной окружения
+func intFromEnv(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		slog.Warn("invalid environment variable, using default", "name", name, "value", value, "default", def)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName – имя трассировщика агента
+const tracerName = "github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/agent"
+
+// taskParent – контекст трассировки задачи из заголовков ответа оркестратора
+func taskParent(header http.Header) trace.SpanContext {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+	return trace.SpanContextFromContext(ctx)
+}
+
+// startTaskSpan – span обработки задачи, дочерний к span постановки задачи в
+// оркестраторе, если он известен. Запрос с результатом, отправленный с
+// возвращённым контекстом, продолжит ту же трассу
+func startTaskSpan(ctx context.Context, task Task) (context.Context, trace.Span) {
+	if task.parent.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, task.parent)
+	}
+	return otel.Tracer(tracerName).Start(ctx, "process task", trace.WithAttributes(
+		attribute.String("task.id", task.ID),
+		attribute.String("task.operation", task.Operation),
+	))
+}
+
+// recordTaskResult – отметка в span ошибки вычисления задачи
+func recordTaskResult(span trace.Span, res Result) {
+	if res.Error != "" {
+		span.SetStatus(codes.Error, res.Error)
+	}
+}
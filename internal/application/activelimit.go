@@ -0,0 +1,84 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// clientIDKey – ключ контекста с клиентом, приславшим выражение
+type clientIDKey struct{}
+
+// withClient – контекст приёма выражения с ключом клиента из запроса (см. clientKey)
+func (a *Application) withClient(r *http.Request) context.Context {
+	return context.WithValue(r.Context(), clientIDKey{}, clientKey(r, a.config.APIKey != ""))
+}
+
+// clientFrom – ключ клиента, приславшего выражение, или пустая строка
+func clientFrom(ctx context.Context) string {
+	client, _ := ctx.Value(clientIDKey{}).(string)
+	return client
+}
+
+// activeLimiter – лимит выражений одного клиента в статусе "pending" или
+// "processing". Выражение занимает место клиента при приёме и освобождает его
+// при переходе в "completed", "error" или "cancelled"
+type activeLimiter struct {
+	mu    sync.Mutex
+	limit int
+	// counts – число активных выражений клиента
+	counts map[string]int
+	// owners – клиент каждого активного выражения
+	owners map[string]string
+}
+
+func newActiveLimiter(limit int) *activeLimiter {
+	return &activeLimiter{
+		limit:  limit,
+		counts: make(map[string]int),
+		owners: make(map[string]string),
+	}
+}
+
+// acquire – учёт выражения id клиента client. false – у клиента уже limit
+// активных выражений
+func (l *activeLimiter) acquire(client, id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts[client] >= l.limit {
+		return false
+	}
+	l.counts[client]++
+	l.owners[id] = client
+	return true
+}
+
+// release – выражение id больше не активно. Повторный вызов ничего не меняет
+func (l *activeLimiter) release(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	client, found := l.owners[id]
+	if !found {
+		return
+	}
+	delete(l.owners, id)
+	if l.counts[client]--; l.counts[client] <= 0 {
+		delete(l.counts, client)
+	}
+}
+
+// acquireActive – место для нового выражения клиента из ctx; 429, если все
+// места заняты
+func (a *Application) acquireActive(ctx context.Context, id string) *submitError {
+	if a.active == nil || a.active.acquire(clientFrom(ctx), id) {
+		return nil
+	}
+	return &submitError{status: http.StatusTooManyRequests, message: "too many active expressions"}
+}
+
+// releaseActive – освобождение места клиента, когда выражение завершилось
+func (a *Application) releaseActive(id string) {
+	if a.active != nil {
+		a.active.release(id)
+	}
+}
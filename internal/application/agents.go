@@ -0,0 +1,140 @@
+package application
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AgentInfo – внешний агент, подключённый к оркестратору. Status – "active",
+// пока heartbeat приходит чаще AgentInactiveAfter, иначе "inactive".
+// Operations – операции, которые считает агент; пусто – любые
+type AgentInfo struct {
+	ID             string    `json:"id"`
+	ComputingPower int       `json:"computing_power"`
+	Operations     []string  `json:"operations,omitempty"`
+	RegisteredAt   time.Time `json:"registered_at"`
+	LastSeen       time.Time `json:"last_seen"`
+	Status         string    `json:"status"`
+}
+
+// agentRegistry – зарегистрированные агенты и время их последнего heartbeat
+type agentRegistry struct {
+	mu     sync.Mutex
+	agents map[string]*AgentInfo
+}
+
+func newAgentRegistry() *agentRegistry {
+	return &agentRegistry{agents: make(map[string]*AgentInfo)}
+}
+
+// register – регистрация (или повторная регистрация) агента
+func (r *agentRegistry) register(id string, computingPower int, operations []string) AgentInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	agent, found := r.agents[id]
+	if !found {
+		agent = &AgentInfo{ID: id, RegisteredAt: now}
+		r.agents[id] = agent
+	}
+	agent.ComputingPower = computingPower
+	agent.Operations = operations
+	agent.LastSeen = now
+	return *agent
+}
+
+// heartbeat – отметка, что агент жив; false, если агент не зарегистрирован
+func (r *agentRegistry) heartbeat(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, found := r.agents[id]
+	if found {
+		agent.LastSeen = time.Now().UTC()
+	}
+	return found
+}
+
+// list – агенты по ID со статусом на текущий момент
+func (r *agentRegistry) list(inactiveAfter time.Duration) []AgentInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	list := make([]AgentInfo, 0, len(r.agents))
+	for _, agent := range r.agents {
+		info := *agent
+		info.Status = "active"
+		if now.Sub(info.LastSeen) > inactiveAfter {
+			info.Status = "inactive"
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// RegisterAgentRequest – регистрация агента; без ID оркестратор выдаёт свой
+type RegisterAgentRequest struct {
+	ID             string   `json:"id"`
+	ComputingPower int      `json:"computing_power"`
+	Operations     []string `json:"operations,omitempty"`
+}
+
+// HeartbeatRequest – сигнал живости агента
+type HeartbeatRequest struct {
+	ID string `json:"id"`
+}
+
+// RegisterAgentHandler – регистрация внешнего агента
+func (a *Application) RegisterAgentHandler(w http.ResponseWriter, r *http.Request) {
+	var req RegisterAgentRequest
+	if !a.readJSON(w, r, &req, "invalid register payload") {
+		return
+	}
+	if req.ComputingPower < 0 {
+		writeError(w, http.StatusBadRequest, "computing_power must not be negative")
+		return
+	}
+	if req.ID == "" {
+		req.ID = generateUniqueID()
+	}
+
+	agent := a.agents.register(req.ID, req.ComputingPower, req.Operations)
+	agent.Status = "active"
+	loggerFrom(r.Context()).Info("агент зарегистрирован", "agent_id", agent.ID, "computing_power", agent.ComputingPower, "operations", agent.Operations)
+	writeJSON(w, http.StatusOK, agent)
+}
+
+// HeartbeatHandler – heartbeat агента; 404 – агент должен зарегистрироваться заново
+func (a *Application) HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	var req HeartbeatRequest
+	if !a.readJSON(w, r, &req, "invalid heartbeat payload") {
+		return
+	}
+	if !a.agents.heartbeat(req.ID) {
+		writeError(w, http.StatusNotFound, "agent not registered")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": req.ID})
+}
+
+// GetAgentsHandler – список агентов с их состоянием для мониторинга
+func (a *Application) GetAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"agents": a.agents.list(a.config.AgentInactiveAfter),
+	})
+}
+
+// parseOperations – список операций агента из строки вида "+,-"; пустая
+// строка – агент считает любые
+func parseOperations(value string) []string {
+	var ops []string
+	for _, op := range strings.Split(value, ",") {
+		if op = strings.TrimSpace(op); op != "" {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
@@ -1,238 +1,364 @@
 package application
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"math"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/Knetic/govaluate"
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
 )
 
-// Request – структура входящего запроса с выражением
+// Request – структура входящего запроса с выражением. CallbackURL – куда
+// отправить POST с итогом, когда выражение будет посчитано. Задачи выражения
+// с большим Priority выдаются агентам раньше (по умолчанию 0). Vars – значения
+// переменных, которые подставляются в выражение по имени: "x * 2 + y"
+// с {"x": 3, "y": 4}
 type Request struct {
-	Expression string `json:"expression"`
+	Expression  string             `json:"expression"`
+	CallbackURL string             `json:"callback_url,omitempty"`
+	Priority    int                `json:"priority,omitempty"`
+	Vars        map[string]float64 `json:"vars,omitempty"`
 }
 
-var expressionsMutex = &sync.Mutex{}
-
-// Expression – структура для хранения выражения и его состояния
+// Expression – структура для хранения выражения и его состояния.
+// Progress – доля посчитанных задач выражения в процентах; время
+// сериализуется в RFC3339. StartedAt – начало текущего вычисления: при
+// перезапуске оно сдвигается, а CreatedAt остаётся прежним
 type Expression struct {
-	ID         string  `json:"id"`
-	Expression string  `json:"expression"`
-	Status     string  `json:"status"`
-	Result     float64 `json:"result,omitempty"`
+	ID             string    `json:"id"`
+	Expression     string    `json:"expression"`
+	Status         string    `json:"status"`
+	Result         float64   `json:"result,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	TotalTasks     int       `json:"total_tasks"`
+	CompletedTasks int       `json:"completed_tasks"`
+	Progress       float64   `json:"progress"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	StartedAt      time.Time `json:"started_at"`
+	CallbackURL    string    `json:"callback_url,omitempty"`
+	Priority       int       `json:"priority,omitempty"`
+	// Vars – переменные из запроса; с ними выражение раскладывается заново
+	// при перезапуске и импорте
+	Vars map[string]float64 `json:"vars,omitempty"`
+}
+
+// taskCompleted – учёт ещё одной посчитанной задачи выражения
+func (e *Expression) taskCompleted() {
+	e.CompletedTasks++
+	if e.TotalTasks > 0 {
+		e.Progress = 100 * float64(e.CompletedTasks) / float64(e.TotalTasks)
+	}
 }
 
-// Task – структура задачи для вычисления
+// expressionStatuses – все статусы, в которых может находиться выражение
+var expressionStatuses = []string{"pending", "processing", "completed", "error", "cancelled"}
+
+// Task – структура задачи для вычисления. Arg1Ref/Arg2Ref – ID задач, результат
+// которых станет соответствующим аргументом; оркестратор подставляет эти результаты
+// и выдаёт агенту только задачи без ссылок. trace – контекст трассировки приёма
+// выражения; внешнему агенту он уходит заголовком traceparent, а не в JSON
 type Task struct {
 	ID            string  `json:"id"`
 	Arg1          float64 `json:"arg1"`
 	Arg2          float64 `json:"arg2"`
+	Arg1Ref       string  `json:"arg1_ref,omitempty"`
+	Arg2Ref       string  `json:"arg2_ref,omitempty"`
 	Operation     string  `json:"operation"`
 	OperationTime int64   `json:"operation_time"`
-}
+	Priority      int     `json:"priority,omitempty"`
 
-// Глобальные переменные для хранения выражений и очереди задач
-var expressions = make(map[string]*Expression)
-var tasks = make(chan Task, 10) // Буферизованный канал для задач
+	trace propagation.MapCarrier
+}
 
-// Config – конфигурация приложения
-type Config struct {
-	Addr string
+// TaskState – задача выражения в ответе GET /api/v1/expressions/{id}/tasks.
+// Status: "waiting" – ждёт результатов других задач, "pending" – в очереди,
+// "processing" – выдана агенту, "completed" – посчитана (Result), "error" –
+// посчитана с ошибкой, "cancelled" – выражение завершилось без неё. Local –
+// унарную операцию или функцию считает сам оркестратор
+type TaskState struct {
+	Task
+	Status string   `json:"status"`
+	Result *float64 `json:"result,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	Local  bool     `json:"local,omitempty"`
 }
 
-// ConfigFromEnv – загрузка конфигурации из переменных окружения
-func ConfigFromEnv() *Config {
-	config := new(Config)
-	config.Addr = os.Getenv("PORT")
-	if config.Addr == "" {
-		config.Addr = "8080"
-	}
-	return config
+// Result – результат вычисления задачи, присылаемый агентом
+type Result struct {
+	ID     string  `json:"id"`
+	Result float64 `json:"result"`
+	Error  string  `json:"error,omitempty"`
 }
 
+// shutdownTimeout – сколько ждать завершения текущих запросов при остановке
+const shutdownTimeout = 10 * time.Second
+
 // Application – основная структура приложения
 type Application struct {
 	config *Config
+	store  Store
+	// tasks – очередь готовых к выдаче задач
+	tasks   *TaskQueue
+	graph   *taskGraph
+	agents  *agentRegistry
+	metrics *metrics
+	// limiter – лимит частоты POST /api/v1/calculate; nil – без ограничения
+	limiter *rateLimiter
+	// dedup – индекс для DeduplicateExpressions; nil – дедупликация выключена
+	dedup *dedupIndex
+	// results – кэш результатов задач; nil – кэш выключен
+	results *resultCache
+	// active – лимит активных выражений одного клиента; nil – без ограничения
+	active *activeLimiter
+	// callbacks – колбэки, которые ещё доставляются
+	callbacks sync.WaitGroup
 }
 
-// New – создание нового экземпляра приложения
-func New() *Application {
-	return &Application{
-		config: ConfigFromEnv(),
-	}
+// New – создание нового экземпляра приложения. Выражения хранятся в SQLite
+// по пути DB_PATH, а при пустом DB_PATH – только в памяти. Незавершённые
+// выражения из хранилища снова раскладываются на задачи
+func New() (*Application, error) {
+	return NewWithConfig(ConfigFromEnv())
 }
 
-// generateUniqueID – генерация уникального идентификатора
-func generateUniqueID() string {
-	return uuid.New().String()
-}
-
-// parseExpression – функция для парсинга математического выражения в формате "<number> <operator> <number>"
-func parseComplexExpression(expr string) (float64, error) {
-	ev, err := govaluate.NewEvaluableExpression(expr)
-	if err != nil {
-		return 0, fmt.Errorf("ошибка при парсинге выражения: %v", err)
+// NewWithConfig – создание приложения с готовой конфигурацией, например
+// из ConfigFromFlags
+func NewWithConfig(config *Config) (*Application, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
-	result, err := ev.Evaluate(nil)
+	store, err := openStore(config)
 	if err != nil {
-		return 0, fmt.Errorf("ошибка при вычислении: %v", err)
+		return nil, err
 	}
-	return result.(float64), nil
-}
-
-// AddExpressionHandler – обработчик POST-запроса для добавления нового выражения
-func AddExpressionHandler(w http.ResponseWriter, r *http.Request) {
-	var req Request
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid expression payload", http.StatusBadRequest)
-		return
+	a := &Application{
+		config: config,
+		store:  store,
+		tasks:  NewTaskQueue(config.TaskQueueSize),
+		graph:  newTaskGraph(),
+		agents: newAgentRegistry(),
 	}
-
-	// Используем функцию parseComplexExpression для вычисления результата
-	result, err := parseComplexExpression(req.Expression)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	a.metrics = newMetrics(a)
+	if config.RateLimitRPS > 0 {
+		a.limiter = newRateLimiter(config.RateLimitRPS, config.APIKey != "")
 	}
-
-	// Генерация уникального ID для выражения
-	expressionID := generateUniqueID()
-
-	expr := &Expression{
-		ID:         expressionID,
-		Expression: req.Expression,
-		Status:     "pending",
-		Result:     result, // Записываем результат сразу
+	if config.DeduplicateExpressions {
+		a.dedup = newDedupIndex(store)
 	}
-
-	// Защищаем доступ к глобальной карте expressions
-	expressionsMutex.Lock()
-	expressions[expressionID] = expr
-	expressionsMutex.Unlock()
-
-	// Возвращаем ответ с ID выражения
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"id": expressionID})
+	if config.ResultCacheSize > 0 {
+		a.results = newResultCache(config.ResultCacheSize)
+	}
+	if config.MaxActiveExpressions > 0 {
+		a.active = newActiveLimiter(config.MaxActiveExpressions)
+	}
+	a.restoreExpressions()
+	return a, nil
 }
 
-func GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
-	var expressionList []Expression
-	for _, expr := range expressions {
-		expressionList = append(expressionList, *expr)
+// openStore – выбор хранилища выражений по конфигурации
+func openStore(config *Config) (Store, error) {
+	if config.DBPath == "" {
+		return NewMemoryStore(), nil
 	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"expressions": expressionList,
-	})
+	return NewSQLiteStore(config.DBPath)
 }
 
-func GetExpressionByIDHandler(w http.ResponseWriter, r *http.Request) {
-	id := mux.Vars(r)["id"]
+// Close – закрытие хранилища выражений
+func (a *Application) Close() error {
+	return a.store.Close()
+}
 
-	expr, found := expressions[id]
-	if !found {
-		http.Error(w, "expression not found", http.StatusNotFound)
-		return
-	}
+// generateUniqueID – генерация уникального идентификатора
+func generateUniqueID() string {
+	return uuid.New().String()
+}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(expr)
+// parseExpression – разбор выражения в дерево, которое затем раскладывается на задачи;
+// переменные заменяются значениями из vars, maxDepth – предел глубины вложенности.
+// Ошибка уходит клиенту как есть, без обёрток
+func parseExpression(expr string, vars map[string]float64, maxDepth int) (*calculation.Node, error) {
+	return calculation.ParseVars(expr, vars, maxDepth)
 }
 
-func GetTaskHandler(w http.ResponseWriter, r *http.Request) {
-	task, found := getNextTaskToProcess()
-	if !found {
-		http.Error(w, "no task available", http.StatusNotFound)
-		return
-	}
+// Handler – маршрутизатор HTTP API приложения. /api/v1/*, /api/v2/* и /internal/*
+// защищены разными ключами, пробы, метрики и документация доступны без авторизации.
+// Preflight-запросы CORS обрабатываются до проверки ключа
+func (a *Application) Handler() http.Handler {
+	r := mux.NewRouter()
+	r.Use(tracingMiddleware)
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(task)
-}
+	var calculate http.Handler = http.HandlerFunc(a.AddExpressionHandler)
+	var batch http.Handler = http.HandlerFunc(a.AddBatchHandler)
+	if a.limiter != nil {
+		calculate = a.limiter.middleware(calculate)
+		batch = a.limiter.middleware(batch)
+	}
+	// /api/v1 и /api/v2 обслуживают одни и те же обработчики; версия из
+	// контекста запроса выбирает запись выражения в ответе
+	var apis []*mux.Router
+	for _, version := range []int{apiV1, apiV2} {
+		api := r.PathPrefix(fmt.Sprintf("/api/v%d", version)).Subrouter()
+		api.Use(requireBearer(a.config.APIKey), withAPIVersion(version))
+		api.Handle("/calculate", calculate).Methods("POST")
+		api.Handle("/calculate/batch", batch).Methods("POST")
+		api.HandleFunc("/validate", a.ValidateExpressionHandler).Methods("POST")
+		api.HandleFunc("/expressions", a.GetExpressionsHandler).Methods("GET")
+		api.HandleFunc("/expressions", a.DeleteExpressionsHandler).Methods("DELETE")
+		api.HandleFunc("/expressions/{id}", a.GetExpressionByIDHandler).Methods("GET")
+		api.HandleFunc("/expressions/{id}", a.DeleteExpressionHandler).Methods("DELETE")
+		api.HandleFunc("/expressions/{id}/result", a.GetExpressionResultHandler).Methods("GET")
+		api.HandleFunc("/expressions/{id}/tasks", a.GetExpressionTasksHandler).Methods("GET")
+		api.HandleFunc("/expressions/{id}/cancel", a.CancelExpressionHandler).Methods("POST")
+		api.HandleFunc("/expressions/{id}/retry", a.RetryExpressionHandler).Methods("POST")
+		api.HandleFunc("/expressions/{id}/stream", a.StreamExpressionHandler).Methods("GET")
+		api.HandleFunc("/stats", a.GetStatsHandler).Methods("GET")
+		api.HandleFunc("/export", a.ExportHandler).Methods("GET")
+		api.HandleFunc("/import", a.ImportHandler).Methods("POST")
+		apis = append(apis, api)
+	}
 
-// Логика обработки задач
-func getNextTaskToProcess() (Task, bool) {
-	select {
-	case task := <-tasks:
-		return task, true
-	default:
-		return Task{}, false
+	internal := r.PathPrefix("/internal").Subrouter()
+	internal.Use(requireBearer(a.config.InternalKey))
+	internal.HandleFunc("/task", a.GetTaskHandler).Methods("GET")
+	internal.HandleFunc("/task", a.SubmitResultHandler).Methods("POST")
+	internal.HandleFunc("/register", a.RegisterAgentHandler).Methods("POST")
+	internal.HandleFunc("/heartbeat", a.HeartbeatHandler).Methods("POST")
+	internal.HandleFunc("/agents", a.GetAgentsHandler).Methods("GET")
+
+	r.HandleFunc("/healthz", a.HealthHandler).Methods("GET")
+	r.HandleFunc("/readyz", a.ReadyHandler).Methods("GET")
+	r.Handle("/metrics", a.metrics.handler()).Methods("GET")
+	r.HandleFunc("/openapi.json", a.OpenAPIHandler).Methods("GET")
+	r.HandleFunc("/docs", a.DocsHandler).Methods("GET")
+	// Подмаршрутизаторы сами отвечают на несовпадение пути и метода, поэтому
+	// JSON-ответы 404 и 405 ставятся и им
+	unmatched := unmatchedHandler(r)
+	for _, router := range append([]*mux.Router{r, internal}, apis...) {
+		router.NotFoundHandler = unmatched
+		router.MethodNotAllowedHandler = unmatched
 	}
+
+	return requestIDMiddleware(corsMiddleware(a.config.CORSAllowedOrigins)(r))
 }
 
-// Функция для выполнения вычислений
-func processTask(task Task) {
-	var result float64
-	switch task.Operation {
-	case "+":
-		result = task.Arg1 + task.Arg2
-	case "-":
-		result = task.Arg1 - task.Arg2
-	case "*":
-		result = task.Arg1 * task.Arg2
-	case "/":
-		if task.Arg2 == 0 {
-			log.Printf("Ошибка: деление на ноль в задаче с ID %s", task.ID)
-			return
-		}
-		result = task.Arg1 / task.Arg2
+// Функция запуска приложения; по SIGINT/SIGTERM сервер перестаёт принимать
+// соединения, дожидается текущих запросов и останавливает агентов
+func (a *Application) RunServer() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Запуск агентов в отдельных горутинах; задачу из канала получает ровно один из них
+	var agents sync.WaitGroup
+	for i := 0; i < a.config.ComputingPower; i++ {
+		agents.Add(1)
+		go func() {
+			defer agents.Done()
+			a.startAgent(ctx)
+		}()
 	}
 
-	// Проверка на NaN или бесконечность
-	if math.IsNaN(result) || math.IsInf(result, 0) {
-		log.Printf("Ошибка: результат вычисления для задачи с ID %s некорректен: %v", task.ID, result)
-		return
+	// Возврат в очередь задач, результат которых внешние агенты не прислали
+	agents.Add(1)
+	go func() {
+		defer agents.Done()
+		a.watchLeases(ctx)
+	}()
+	agents.Add(1)
+	go func() {
+		defer agents.Done()
+		a.runJanitor(ctx)
+	}()
+
+	srv := &http.Server{
+		Addr:    a.config.ListenAddr(),
+		Handler: a.Handler(),
 	}
 
-	// Обновляем статус задачи на "completed" и сохраняем результат
-	expressionsMutex.Lock()
-	expr, found := expressions[task.ID]
-	if found {
-		expr.Status = "completed"
-		expr.Result = result
+	// gRPC-сервер для внешних агентов, если задан GRPC_PORT
+	var grpcServer *grpc.Server
+	if a.config.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", listenAddr(a.config.GRPCAddr))
+		if err != nil {
+			stop()
+			agents.Wait()
+			return fmt.Errorf("ошибка при запуске gRPC-сервера: %w", err)
+		}
+		grpcServer = a.GRPCServer()
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				slog.Error("ошибка gRPC-сервера", "error", err)
+			}
+		}()
+		slog.Info("запуск gRPC-сервера", "port", a.config.GRPCAddr)
 	}
-	expressionsMutex.Unlock()
-
-	log.Printf("Задача с ID %s обработана, результат: %f", task.ID, result)
-}
 
-// Запуск агента для обработки задач
-func startAgent() {
-	for {
-		task, found := getNextTaskToProcess()
-		if found {
-			processTask(task)
-		} else {
-			log.Println("Задач нет в очереди, агент ожидает...")
-			time.Sleep(1 * time.Second) // Пауза, если задач нет
+	// Перенаправление с HTTP на HTTPS, если задан HTTP_REDIRECT_PORT
+	var redirectServer *http.Server
+	if a.config.TLSEnabled() && a.config.HTTPRedirectAddr != "" {
+		redirectServer = &http.Server{
+			Addr:    listenAddr(a.config.HTTPRedirectAddr),
+			Handler: httpsRedirect(a.config.Addr),
 		}
+		go func() {
+			if err := redirectServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("ошибка сервера перенаправления на HTTPS", "error", err)
+			}
+		}()
+		slog.Info("запуск перенаправления на HTTPS", "port", a.config.HTTPRedirectAddr)
 	}
-}
 
-// Функция запуска приложения
-func (a *Application) RunServer() error {
-	r := mux.NewRouter()
-
-	r.HandleFunc("/api/v1/calculate", AddExpressionHandler).Methods("POST")
-	r.HandleFunc("/api/v1/expressions", GetExpressionsHandler).Methods("GET")
-	r.HandleFunc("/api/v1/expressions/{id}", GetExpressionByIDHandler).Methods("GET")
-	r.HandleFunc("/internal/task", GetTaskHandler).Methods("GET")
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		slog.Info("остановка сервера")
+		if redirectServer != nil {
+			redirectServer.Close()
+		}
+		if grpcServer != nil {
+			// Стримы агентов бесконечны, поэтому не ждём их: выданные задачи
+			// вернутся в очередь по VisibilityTimeout
+			grpcServer.Stop()
+		}
 
-	go startAgent() // Запуск агента в отдельной горутине
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("ошибка при остановке сервера", "error", err)
+		}
+	}()
 
-	fmt.Println("Запуск сервера на порту " + a.config.Addr)
+	slog.Info("запуск сервера", "port", a.config.Addr, "tls", a.config.TLSEnabled())
 
-	if err := http.ListenAndServe(":"+a.config.Addr, r); err != nil {
-		log.Fatal("Ошибка при запуске сервера:", err)
+	// Единственный запуск сервера; ошибку прослушивания отдаём вызывающему
+	var err error
+	if a.config.TLSEnabled() {
+		err = srv.ListenAndServeTLS(a.config.TLSCert, a.config.TLSKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		stop()
+		agents.Wait()
+		return fmt.Errorf("ошибка при запуске сервера: %w", err)
 	}
-	return http.ListenAndServe(":"+a.config.Addr, r)
+
+	<-shutdownDone
+	agents.Wait()
+	a.callbacks.Wait()
+	slog.Info("сервер остановлен")
+	return nil
 }
@@ -1,42 +1,3111 @@
 package application_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/application"
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/taskpb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// TestMain – по умолчанию тесты хранят выражения в памяти, а не в calc.db
+func TestMain(m *testing.M) {
+	os.Setenv("DB_PATH", "")
+	os.Exit(m.Run())
+}
+
+// newApp – приложение с конфигурацией из окружения, закрываемое после теста
+func newApp(t *testing.T) *application.Application {
+	t.Helper()
+	app, err := application.New()
+	if err != nil {
+		t.Fatalf("failed to create application: %v", err)
+	}
+	t.Cleanup(func() { app.Close() })
+	return app
+}
+
 func TestCalcHandler(t *testing.T) {
 	tests := []struct {
-		expression     string
-		expected       string
+		name           string
+		body           string
 		expectedStatus int
+		expectedError  string
 	}{
-		{"2+2", "result: 4.000000", http.StatusOK},
-		{"2/0", "err: division by zero", http.StatusBadRequest},
-		{"abc", "err: invalid expression", http.StatusBadRequest},
-		{"", "EOF", http.StatusBadRequest},
-		{"invalid", "internal server error", http.StatusInternalServerError},
+		{"valid expression", `{"expression":"2+2"}`, http.StatusCreated, ""},
+		{"constant out of domain", `{"expression":"sqrt(-4) + 1"}`, http.StatusUnprocessableEntity, "function argument out of domain"},
+		{"integer division of fraction", `{"expression":"7.5 // 2"}`, http.StatusUnprocessableEntity, "operands must be integers"},
+		{"invalid expression", `{"expression":"abc"}`, http.StatusUnprocessableEntity, "invalid expression"},
+		{"unbalanced parentheses", `{"expression":"(3 + 4"}`, http.StatusUnprocessableEntity, "unbalanced parentheses"},
+		{"empty expression", `{"expression":""}`, http.StatusUnprocessableEntity, ""},
+		{"empty body", ``, http.StatusBadRequest, "empty body"},
+		{"invalid json", `{"expression":`, http.StatusBadRequest, "unexpected end of JSON"},
+		{"broken json", `{"expression" "2+2"}`, http.StatusBadRequest, "at position 15"},
+		{"wrong field type", `{"expression":2}`, http.StatusBadRequest, `field "expression" must be string`},
+		{"unknown field", `{"expresion":"2+2"}`, http.StatusBadRequest, `unknown field "expresion"`},
+		{"missing expression", `{}`, http.StatusUnprocessableEntity, `field "expression" is required`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := newApp(t)
+			req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(test.body))
+			w := httptest.NewRecorder()
+
+			app.AddExpressionHandler(w, req)
+
+			res := w.Result()
+			if res.StatusCode != test.expectedStatus {
+				t.Fatalf("expected status %v, got %v", test.expectedStatus, res.StatusCode)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("expected Content-Type application/json, got %q", ct)
+			}
+			if res.StatusCode == http.StatusCreated {
+				// Принятое выражение доступно по возвращённому ID и досчитывается агентом
+				var created map[string]string
+				if err := json.NewDecoder(res.Body).Decode(&created); err != nil || created["id"] == "" {
+					t.Fatalf("expected {\"id\": ...}, got error %v, body %v", err, created)
+				}
+				startAgent(t, app)
+				expr := waitForExpression(t, app.Handler(), created["id"])
+				if expr.Status != "completed" || expr.Result != 4 {
+					t.Fatalf("expected completed 4, got %s %v", expr.Status, expr.Result)
+				}
+				return
+			}
+
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatalf("error response is not JSON: %v", err)
+			}
+			if body.Error == "" {
+				t.Fatalf("error response has no \"error\" field: %v", body)
+			}
+			if !strings.Contains(body.Error, test.expectedError) {
+				t.Fatalf("expected error containing %q, got %q", test.expectedError, body.Error)
+			}
+			// Клиенту уходят английские сообщения, русский текст – только в логах
+			if strings.ContainsFunc(body.Error, func(r rune) bool { return unicode.Is(unicode.Cyrillic, r) }) {
+				t.Fatalf("expected English error, got %q", body.Error)
+			}
+		})
 	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
 
+	tests := []struct {
+		expression string
+		position   int
+		token      string
+		message    string
+	}{
+		{"2 + 3 * * 4", 8, "*", "expected operand"},
+		{"(3 + 4", 6, "", "missing"},
+		{"3 + 4)", 5, ")", "unbalanced parentheses"},
+		{"2 $ 2", 2, "$", "unexpected character"},
+		{"2 3", 2, "3", "expected operator"},
+		{"1 + foo", 4, "foo", `undefined variable \"foo\"`},
+		{"sqrt(4", 6, "", "missing"},
+	}
 	for _, test := range tests {
-		reqBody := `{"expression":"` + test.expression + `"}`
+		for _, path := range []string{"/api/v1/calculate", "/api/v1/validate"} {
+			body, _ := json.Marshal(map[string]string{"expression": test.expression})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewReader(body)))
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("%s %q: expected 422, got %d", path, test.expression, w.Code)
+			}
+			var got struct {
+				Error    string  `json:"error"`
+				Position *int    `json:"position"`
+				Token    *string `json:"token"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%s %q: invalid response: %v", path, test.expression, err)
+			}
+			if got.Position == nil || *got.Position != test.position || got.Token == nil || *got.Token != test.token {
+				t.Errorf("%s %q: expected position %d token %q, got %s", path, test.expression, test.position, test.token, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), test.message) {
+				t.Errorf("%s %q: expected error containing %q, got %s", path, test.expression, test.message, w.Body.String())
+			}
+		}
+	}
+
+	// У отказов не из-за синтаксиса позиции нет
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression": "sqrt(-4) + 1"}`)))
+	if w.Code != http.StatusUnprocessableEntity || strings.Contains(w.Body.String(), "position") {
+		t.Fatalf("expected 422 without position, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+// startAgent – запуск встроенного агента на время теста
+func startAgent(t *testing.T, app *application.Application) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go app.StartAgent(ctx)
+}
 
-		req := httptest.NewRequest("POST", "/", bytes.NewBufferString(reqBody))
+// submitExpression – отправка выражения через роутер, возвращает ID
+func submitExpression(t *testing.T, router http.Handler, expression string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"expression": expression})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %v, got %v", http.StatusCreated, w.Code)
+	}
+	var created map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return created["id"]
+}
+
+// waitForExpression – опрос выражения по ID, пока оно не выйдет из pending/processing
+func waitForExpression(t *testing.T, router http.Handler, id string) application.Expression {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
 		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/expressions/"+id, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %v, got %v", http.StatusOK, w.Code)
+		}
+		expr := decodeExpression(t, w.Body)
+		if expr.Status != "pending" && expr.Status != "processing" {
+			return expr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expression is still %q", expr.Status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestExpressionCompleted(t *testing.T) {
+	app := newApp(t)
+	router := app.Handler()
+	startAgent(t, app)
+
+	expr := waitForExpression(t, router, submitExpression(t, router, "(2 + 3) * 4"))
+	if expr.Status != "completed" || expr.Result != 20 {
+		t.Fatalf("expected completed/20, got %s/%v", expr.Status, expr.Result)
+	}
+}
+
+func TestExpressionFailed(t *testing.T) {
+	app := newApp(t)
+	router := app.Handler()
+	startAgent(t, app)
+
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{"2 / 0", "division by zero"},
+		{"(1 + 1) / (3 - 3)", "division by zero"},
+		{"10 ^ 400", "overflow"},
+		{"1e308 * 10", "overflow"},
+		{"-1e308 - 1e308", "overflow"},
+		{"sqrt(1 - 5)", "function argument out of domain"},
+	}
+	for _, test := range tests {
+		expr := waitForExpression(t, router, submitExpression(t, router, test.expression))
+		if expr.Status != "error" || expr.Error != test.err {
+			t.Fatalf("%s: expected error %q, got %s %q", test.expression, test.err, expr.Status, expr.Error)
+		}
+	}
+}
+
+// fetchTask – получение задачи через /internal/task, как это делает внешний агент
+func fetchTask(t *testing.T, router http.Handler) application.Task {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, w.Code)
+	}
+	var task application.Task
+	if err := json.NewDecoder(w.Body).Decode(&task); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return task
+}
+
+// submitResult – отправка результата задачи, как это делает внешний агент
+func submitResult(t *testing.T, router http.Handler, body string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/internal/task", bytes.NewBufferString(body)))
+	return w.Code
+}
+
+func TestSubmitResultHandler(t *testing.T) {
+	router := newApp(t).Handler()
+
+	okID := submitExpression(t, router, "6 * 7")
+	okTask := fetchTask(t, router)
+	if code := submitResult(t, router, `{"id":"`+okTask.ID+`","result":42}`); code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, code)
+	}
+	if expr := waitForExpression(t, router, okID); expr.Status != "completed" || expr.Result != 42 {
+		t.Fatalf("expected completed/42, got %s/%v", expr.Status, expr.Result)
+	}
+
+	errID := submitExpression(t, router, "1 / 0")
+	errTask := fetchTask(t, router)
+	if code := submitResult(t, router, `{"id":"`+errTask.ID+`","error":"division by zero"}`); code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, code)
+	}
+	if expr := waitForExpression(t, router, errID); expr.Status != "error" || expr.Error != "division by zero" {
+		t.Fatalf("expected error \"division by zero\", got %s %q", expr.Status, expr.Error)
+	}
+
+	if code := submitResult(t, router, `{"id":"missing","result":1}`); code != http.StatusNotFound {
+		t.Fatalf("unknown task: expected status %v, got %v", http.StatusNotFound, code)
+	}
+	if code := submitResult(t, router, `{"id":`); code != http.StatusBadRequest {
+		t.Fatalf("invalid json: expected status %v, got %v", http.StatusBadRequest, code)
+	}
+}
+
+func TestExpressionDecomposition(t *testing.T) {
+	router := newApp(t).Handler()
+	id := submitExpression(t, router, "(2 + 2) * 3 - 1")
+
+	// Сначала доступна только задача без зависимостей
+	first := fetchTask(t, router)
+	if first.Operation != "+" || first.Arg1 != 2 || first.Arg2 != 2 {
+		t.Fatalf("expected task 2 + 2, got %v %s %v", first.Arg1, first.Operation, first.Arg2)
+	}
+	if code := submitResult(t, router, `{"id":"`+first.ID+`","result":4}`); code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, code)
+	}
+
+	// Результат подставляется аргументом в родительскую операцию
+	second := fetchTask(t, router)
+	if second.Operation != "*" || second.Arg1 != 4 || second.Arg2 != 3 {
+		t.Fatalf("expected task 4 * 3, got %v %s %v", second.Arg1, second.Operation, second.Arg2)
+	}
+	submitResult(t, router, `{"id":"`+second.ID+`","result":12}`)
+
+	third := fetchTask(t, router)
+	if third.Operation != "-" || third.Arg1 != 12 || third.Arg2 != 1 {
+		t.Fatalf("expected task 12 - 1, got %v %s %v", third.Arg1, third.Operation, third.Arg2)
+	}
+	submitResult(t, router, `{"id":"`+third.ID+`","result":11}`)
+
+	if expr := waitForExpression(t, router, id); expr.Status != "completed" || expr.Result != 11 {
+		t.Fatalf("expected completed/11, got %s/%v", expr.Status, expr.Result)
+	}
+}
+
+func TestTaskReferences(t *testing.T) {
+	router := newApp(t).Handler()
+	id := submitExpression(t, router, "2 + 2 * 2")
+
+	// Сложение ссылается на результат умножения, поэтому умножение выдаётся первым
+	mul := fetchTask(t, router)
+	if mul.Operation != "*" || mul.Arg1 != 2 || mul.Arg2 != 2 {
+		t.Fatalf("expected task 2 * 2, got %v %s %v", mul.Arg1, mul.Operation, mul.Arg2)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("addition was handed out before its argument was computed: status %v", w.Code)
+	}
+	submitResult(t, router, `{"id":"`+mul.ID+`","result":4}`)
+
+	add := fetchTask(t, router)
+	if add.Operation != "+" || add.Arg1 != 2 || add.Arg2 != 4 || add.Arg1Ref != "" || add.Arg2Ref != "" {
+		t.Fatalf("expected resolved task 2 + 4, got %+v", add)
+	}
+	submitResult(t, router, `{"id":"`+add.ID+`","result":6}`)
+
+	if expr := waitForExpression(t, router, id); expr.Status != "completed" || expr.Result != 6 {
+		t.Fatalf("expected completed/6, got %s/%v", expr.Status, expr.Result)
+	}
+}
+
+// getTasks – задачи выражения через GET /api/v1/expressions/{id}/tasks
+func getTasks(t *testing.T, router http.Handler, id string) []application.TaskState {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions/"+id+"/tasks", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, w.Code)
+	}
+	var resp struct {
+		Tasks []application.TaskState `json:"tasks"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return resp.Tasks
+}
+
+func TestExpressionTasks(t *testing.T) {
+	router := newApp(t).Handler()
+
+	statuses := func(tasks []application.TaskState) string {
+		var parts []string
+		for _, task := range tasks {
+			parts = append(parts, task.Operation+":"+task.Status)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	id := submitExpression(t, router, "(2 + 3) * 4")
+	if got := statuses(getTasks(t, router, id)); got != "+:pending *:waiting" {
+		t.Fatalf("after submit: got %q", got)
+	}
+
+	add := fetchTask(t, router)
+	if got := statuses(getTasks(t, router, id)); got != "+:processing *:waiting" {
+		t.Fatalf("after fetch: got %q", got)
+	}
+
+	submitResult(t, router, `{"id":"`+add.ID+`","result":5}`)
+	tasks := getTasks(t, router, id)
+	if got := statuses(tasks); got != "+:completed *:pending" {
+		t.Fatalf("after result: got %q", got)
+	}
+	if tasks[0].Result == nil || *tasks[0].Result != 5 {
+		t.Fatalf("expected intermediate result 5, got %v", tasks[0].Result)
+	}
+	if tasks[1].Arg1 != 5 || tasks[1].Arg1Ref != "" {
+		t.Fatalf("expected resolved argument 5, got %+v", tasks[1])
+	}
+
+	mul := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+mul.ID+`","error":"boom"}`)
+	tasks = getTasks(t, router, id)
+	if got := statuses(tasks); got != "+:completed *:error" || tasks[1].Error != "boom" {
+		t.Fatalf("after error: got %q, %+v", got, tasks[1])
+	}
+
+	// Отменённое выражение так и не дошло до своих задач
+	cancelled := submitExpression(t, router, "1 + 1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/expressions/"+cancelled+"/cancel", nil))
+	if got := statuses(getTasks(t, router, cancelled)); got != "+:cancelled" {
+		t.Fatalf("cancelled expression: got %q", got)
+	}
+
+	// У выражения без операций задач нет
+	if tasks := getTasks(t, router, submitExpression(t, router, "-7")); len(tasks) != 0 {
+		t.Fatalf("expected no tasks, got %+v", tasks)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions/missing/tasks", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown expression: expected status %v, got %v", http.StatusNotFound, w.Code)
+	}
+}
+
+// getExpression – получение выражения по ID через роутер
+func getExpression(t *testing.T, router http.Handler, id string) application.Expression {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/expressions/"+id, nil))
+	return decodeExpression(t, w.Body)
+}
+
+// decodeExpression – разбор выражения в формате /api/v2: в /api/v1 есть только
+// id, expression, status и result
+func decodeExpression(t *testing.T, r io.Reader) application.Expression {
+	t.Helper()
+	var v2 struct {
+		application.Expression
+		Result   *float64 `json:"result"`
+		Progress struct {
+			CompletedTasks int     `json:"completed_tasks"`
+			TotalTasks     int     `json:"total_tasks"`
+			Percent        float64 `json:"percent"`
+		} `json:"progress"`
+	}
+	if err := json.NewDecoder(r).Decode(&v2); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	expr := v2.Expression
+	if v2.Result != nil {
+		expr.Result = *v2.Result
+	}
+	expr.CompletedTasks = v2.Progress.CompletedTasks
+	expr.TotalTasks = v2.Progress.TotalTasks
+	expr.Progress = v2.Progress.Percent
+	return expr
+}
+
+func TestExpressionProgress(t *testing.T) {
+	router := newApp(t).Handler()
+
+	// Одна операция: 0 -> 100
+	single := submitExpression(t, router, "2 * 3")
+	if expr := getExpression(t, router, single); expr.TotalTasks != 1 || expr.Progress != 0 {
+		t.Fatalf("expected 1 task and progress 0, got %d and %v", expr.TotalTasks, expr.Progress)
+	}
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":6}`)
+	if expr := getExpression(t, router, single); expr.CompletedTasks != 1 || expr.Progress != 100 {
+		t.Fatalf("expected 1 completed task and progress 100, got %d and %v", expr.CompletedTasks, expr.Progress)
+	}
+
+	// Несколько операций: прогресс растёт по мере подсчёта задач
+	multi := submitExpression(t, router, "(1 + 1) * (2 + 2)")
+	if expr := getExpression(t, router, multi); expr.TotalTasks != 3 {
+		t.Fatalf("expected 3 tasks, got %d", expr.TotalTasks)
+	}
+	for i := 0; i < 3; i++ {
+		task := fetchTask(t, router)
+		submitResult(t, router, `{"id":"`+task.ID+`","result":2}`)
+		expr := getExpression(t, router, multi)
+		if want := 100 * float64(i+1) / 3; expr.Progress != want {
+			t.Fatalf("step %d: expected progress %v, got %v", i+1, want, expr.Progress)
+		}
+	}
+
+	// Выражение без операций посчитано сразу
+	constant := submitExpression(t, router, "sqrt(16)")
+	if expr := getExpression(t, router, constant); expr.Status != "completed" || expr.Result != 4 || expr.Progress != 100 {
+		t.Fatalf("expected completed/4 with progress 100, got %s/%v with %v", expr.Status, expr.Result, expr.Progress)
+	}
+}
+
+func TestDecomposedExpressionsWithAgent(t *testing.T) {
+	app := newApp(t)
+	router := app.Handler()
+	startAgent(t, app)
 
-		CalcHandler(w, req)
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"(2 + 2) * 3 - 1", 11},
+		{"sqrt(2 * 8) + -(3 * 1)", 1},
+		{"2 ^ (1 + 2) / (10 - 6)", 2},
+		{"-sqrt(16)", -4},
+		{"2 * 3 > 5", 1},
+		{"(1 + 1 == 2) + (3 != 3)", 1},
+	}
+	for _, test := range tests {
+		expr := waitForExpression(t, router, submitExpression(t, router, test.expression))
+		if expr.Status != "completed" || expr.Result != test.expected {
+			t.Fatalf("%s: expected completed/%v, got %s/%v %s", test.expression, test.expected, expr.Status, expr.Result, expr.Error)
+		}
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	app := newApp(t)
+	router := app.Handler()
+	startAgent(t, app)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression":"1 + 1"}`)))
+			if w.Code != http.StatusCreated {
+				t.Errorf("expected status %v, got %v", http.StatusCreated, w.Code)
+				return
+			}
+			var created map[string]string
+			json.NewDecoder(w.Body).Decode(&created)
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/expressions/"+created["id"], nil))
+		}()
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status %v, got %v", http.StatusOK, w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestListWhileProcessing(t *testing.T) {
+	app := newApp(t)
+	router := app.Handler()
+	if err := app.PutExpression(&application.Expression{ID: "list-processing", Expression: "2 + 2", Status: "processing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	id := submitExpression(t, router, "3 * 3")
+
+	statuses := make(map[string]string)
+	for _, expr := range listExpressions(t, router, "").Expressions {
+		statuses[expr.ID] = expr.Status
+	}
+	if statuses["list-processing"] != "processing" {
+		t.Fatalf("expected processing expression in the list, got %q", statuses["list-processing"])
+	}
+	if statuses[id] == "" {
+		t.Fatalf("just submitted expression %s is missing from the list", id)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("TIME_ADDITION_MS", "10")
+	t.Setenv("TIME_SUBTRACTION_MS", "20")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "30")
+	t.Setenv("TIME_DIVISIONS_MS", "invalid")
+
+	config := application.ConfigFromEnv()
+	expected := map[string]int64{"+": 10, "-": 20, "*": 30, "/": 100, "^": 30, "%": 100, "//": 100, "<": 20, "==": 20}
+	for op, ms := range expected {
+		if got := config.OperationTime(op); got != ms {
+			t.Errorf("operation %s: expected %d ms, got %d ms", op, ms, got)
+		}
+	}
+}
+
+func TestTaskOperationTime(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("TIME_ADDITION_MS", "10")
+	t.Setenv("TIME_SUBTRACTION_MS", "20")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "30")
+	t.Setenv("TIME_DIVISIONS_MS", "40")
+	router := newApp(t).Handler()
+
+	expected := map[string]int64{"+": 10, "-": 20, "*": 30, "/": 40, "^": 30, "%": 40, "//": 40}
+	for op := range expected {
+		submitExpression(t, router, "7 "+op+" 2")
+	}
+	// Агент получает время операции вместе с задачей
+	for range expected {
+		task := fetchTask(t, router)
+		if task.OperationTime != expected[task.Operation] {
+			t.Errorf("operation %s: expected %d ms, got %d ms", task.Operation, expected[task.Operation], task.OperationTime)
+		}
+	}
+}
+
+func TestParallelTaskDistribution(t *testing.T) {
+	router := newApp(t).Handler()
+
+	for i := 0; i < 5; i++ {
+		submitExpression(t, router, "2 * 3")
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[string]int)
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task", nil))
+			if w.Code != http.StatusOK {
+				return
+			}
+			var task application.Task
+			if err := json.NewDecoder(w.Body).Decode(&task); err != nil {
+				t.Errorf("invalid response: %v", err)
+				return
+			}
+			mu.Lock()
+			seen[task.ID]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for id, n := range seen {
+		if n > 1 {
+			t.Fatalf("task %s was handed out %d times", id, n)
+		}
+	}
+}
+
+// TestConcurrentSubmissions – много клиентов одновременно отправляют выражения
+// на настоящий HTTP-сервер, а встроенные агенты их считают. Смысл теста –
+// в запуске с -race
+func TestConcurrentSubmissions(t *testing.T) {
+	for _, name := range []string{"TIME_ADDITION_MS", "TIME_SUBTRACTION_MS", "TIME_MULTIPLICATIONS_MS", "TIME_DIVISIONS_MS"} {
+		t.Setenv(name, "0")
+	}
+	app := newApp(t)
+	for i := 0; i < 4; i++ {
+		startAgent(t, app)
+	}
+	server := httptest.NewServer(app.Handler())
+	defer server.Close()
+
+	const n = 50
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]float64)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"expression":"%d + %d * 2"}`, i, i)
+			resp, err := http.Post(server.URL+"/api/v1/calculate", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			var created map[string]string
+			if resp.StatusCode != http.StatusCreated || json.NewDecoder(resp.Body).Decode(&created) != nil {
+				t.Errorf("expression %d: unexpected response %d", i, resp.StatusCode)
+				return
+			}
+			mu.Lock()
+			results[created["id"]] = float64(3 * i)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	if len(results) != n {
+		t.Fatalf("expected %d distinct IDs, got %d", n, len(results))
+	}
+
+	router := app.Handler()
+	deadline := time.Now().Add(10 * time.Second)
+	for listExpressions(t, router, "?status=completed&limit=100").Total < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("not all expressions completed: %+v", listExpressions(t, router, "?limit=100"))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	page := listExpressions(t, router, "?limit=100")
+	if page.Total != n {
+		t.Fatalf("expected %d expressions, got %d", n, page.Total)
+	}
+	for _, expr := range page.Expressions {
+		want, found := results[expr.ID]
+		if !found || expr.Status != "completed" || expr.Result != want {
+			t.Errorf("expression %s %q: expected completed %v, got %s %v", expr.ID, expr.Expression, want, expr.Status, expr.Result)
+		}
+	}
+}
 
-		res := w.Result()
+func TestTaskQueue(t *testing.T) {
+	q := application.NewTaskQueue(2)
+	if _, ok := q.Pop(); ok {
+		t.Fatal("empty queue returned a task")
+	}
+
+	q.Push(application.Task{ID: "low-1"})
+	q.Push(application.Task{ID: "high", Priority: 1})
+	q.Push(application.Task{ID: "low-2"})
+	if q.Len() != 3 {
+		t.Fatalf("expected 3 tasks, got %d", q.Len())
+	}
+	// Push не ограничен ёмкостью, а PushWait ждёт места
+	deadline := time.After(50 * time.Millisecond)
+	if q.PushWait(context.Background(), application.Task{ID: "late"}, deadline) {
+		t.Fatal("PushWait should time out on a full queue")
+	}
 
-		if res.StatusCode != test.expectedStatus {
-			t.Errorf("for expression %q: expected status %v, got %v", test.expression, test.expectedStatus, res.StatusCode)
+	for _, id := range []string{"high", "low-1", "low-2"} {
+		task, ok := q.Pop()
+		if !ok || task.ID != id {
+			t.Fatalf("expected %s, got %+v", id, task)
 		}
+	}
+
+	// Wait дожидается задачи, поставленной из другой горутины
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.Push(application.Task{ID: "waited"})
+	}()
+	if task, ok := q.Wait(context.Background(), time.Second); !ok || task.ID != "waited" {
+		t.Fatalf("expected waited task, got %+v", task)
+	}
+	if _, ok := q.Wait(context.Background(), 10*time.Millisecond); ok {
+		t.Fatal("Wait on empty queue should time out")
+	}
+}
+
+func TestTaskQueueOperations(t *testing.T) {
+	q := application.NewTaskQueue(10)
+	q.Push(application.Task{ID: "div", Operation: "/"})
+	q.Push(application.Task{ID: "add", Operation: "+"})
+	q.Push(application.Task{ID: "sub", Operation: "-", Priority: 1})
 
-		body := w.Body.String()
-		if body != test.expected {
-			t.Errorf("for expression %q: expected body %q, got %q", test.expression, test.expected, body)
+	// Агент, который считает только сложение, не ждёт за делением
+	if task, ok := q.Pop("+"); !ok || task.ID != "add" {
+		t.Fatalf("expected add, got %+v", task)
+	}
+	if task, ok := q.Pop("+"); ok {
+		t.Fatalf("expected no tasks for +, got %+v", task)
+	}
+	// Без операций – любая задача в общем порядке приоритетов
+	for _, id := range []string{"sub", "div"} {
+		if task, ok := q.Pop(); !ok || task.ID != id {
+			t.Fatalf("expected %s, got %+v", id, task)
 		}
 	}
+
+	// Задача чужой операции не будит ждущего
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.Push(application.Task{ID: "mul", Operation: "*"})
+		time.Sleep(20 * time.Millisecond)
+		q.Push(application.Task{ID: "add-2", Operation: "+"})
+	}()
+	if task, ok := q.Wait(context.Background(), time.Second, "+", "-"); !ok || task.ID != "add-2" {
+		t.Fatalf("expected add-2, got %+v", task)
+	}
+	if q.Len() != 1 {
+		t.Fatalf("expected the multiplication to stay queued, got %d tasks", q.Len())
+	}
+
+	// Внешний агент передаёт свои операции параметром ops
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+	submitExpression(t, router, "8 / 2")
+	submitExpression(t, router, "1 + 1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task?ops=%2B,-", nil))
+	var task application.Task
+	json.NewDecoder(w.Body).Decode(&task)
+	if w.Code != http.StatusOK || task.Operation != "+" {
+		t.Fatalf("expected the addition, got %d %+v", w.Code, task)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task?ops=%2B,-", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without matching tasks, got %d", w.Code)
+	}
+	if task := fetchTask(t, router); task.Operation != "/" {
+		t.Fatalf("expected the division for an agent without ops, got %+v", task)
+	}
+}
+
+func TestTaskPriority(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	submitExpression(t, router, "5 - 1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression":"(1 + 2) * 3","priority":5}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %v, got %v", http.StatusCreated, w.Code)
+	}
+
+	// Срочное выражение поставлено позже, но его задача выдаётся первой
+	task := fetchTask(t, router)
+	if task.Operation != "+" || task.Priority != 5 {
+		t.Fatalf("expected high-priority task \"1 + 2\", got %+v", task)
+	}
+	submitResult(t, router, fmt.Sprintf(`{"id":%q,"result":3}`, task.ID))
+
+	// Задача, ставшая готовой, наследует приоритет выражения
+	task = fetchTask(t, router)
+	if task.Operation != "*" || task.Priority != 5 {
+		t.Fatalf("expected inherited priority for \"3 * 3\", got %+v", task)
+	}
+	if task = fetchTask(t, router); task.Operation != "-" {
+		t.Fatalf("expected low-priority task last, got %+v", task)
+	}
+}
+
+func TestTaskQueueFull(t *testing.T) {
+	t.Setenv("TASK_QUEUE_TIMEOUT_MS", "500")
+	app := newApp(t)
+	router := app.Handler()
+
+	for i := 0; i < app.TaskQueueCap(); i++ {
+		submitExpression(t, router, "1 + 1")
+	}
+	countBefore := listExpressions(t, router, "").Total
+
+	// Места нет и никто не освобождает очередь – 503 с Retry-After, выражение не сохраняется
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression":"2 + 2"}`)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %v, got %v", http.StatusServiceUnavailable, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+	if body := w.Body.String(); !strings.Contains(body, "task queue is full, retry later") {
+		t.Fatalf("expected English error message, got %s", body)
+	}
+	if count := listExpressions(t, router, "").Total; count != countBefore {
+		t.Fatalf("rejected expression was stored: %d expressions, expected %d", count, countBefore)
+	}
+
+	// Агент освобождает место, пока запрос ждёт – выражение принимается
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/internal/task", nil))
+	}()
+	id := submitExpression(t, router, "3 + 3")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions/"+id, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %v, got %v", http.StatusOK, w.Code)
+	}
+}
+
+// listExpressions – получение списка выражений через роутер
+// expressionPage – страница списка выражений
+type expressionPage struct {
+	Expressions []application.Expression `json:"expressions"`
+	Total       int                      `json:"total"`
+}
+
+func listExpressions(t *testing.T, router http.Handler, query string) expressionPage {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions"+query, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var page expressionPage
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return page
+}
+
+func TestApplicationsAreIsolated(t *testing.T) {
+	first, second := newApp(t), newApp(t)
+	id := submitExpression(t, first.Handler(), "1 + 2")
+
+	w := httptest.NewRecorder()
+	second.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions/"+id, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expression leaked into another application: status %v", w.Code)
+	}
+}
+
+func TestSQLiteStorePersistsExpressions(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "calc.db"))
+	t.Setenv("COMPUTING_POWER", "0")
+
+	app := newApp(t)
+	router := app.Handler()
+	id := submitExpression(t, router, "2 * 3")
+	task := fetchTask(t, router)
+	if status := submitResult(t, router, `{"id":"`+task.ID+`","result":6}`); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if err := app.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	// Новый экземпляр над той же базой видит посчитанное выражение
+	restarted := newApp(t)
+	expr := getExpression(t, restarted.Handler(), id)
+	if expr.Status != "completed" || expr.Result != 6 || expr.Progress != 100 {
+		t.Fatalf("expected completed expression with result 6, got %+v", expr)
+	}
+}
+
+func TestRestoreUnfinishedExpressions(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "calc.db"))
+	t.Setenv("COMPUTING_POWER", "0")
+
+	app := newApp(t)
+	if err := app.PutExpression(&application.Expression{ID: "restored", Expression: "2 + 3", Status: "processing", TotalTasks: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	// После «перезапуска» задача выражения снова доступна агентам
+	router := newApp(t).Handler()
+	task := fetchTask(t, router)
+	if task.Operation != "+" || task.Arg1 != 2 || task.Arg2 != 3 {
+		t.Fatalf("unexpected restored task: %+v", task)
+	}
+	if status := submitResult(t, router, `{"id":"`+task.ID+`","result":5}`); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if expr := getExpression(t, router, "restored"); expr.Status != "completed" || expr.Result != 5 {
+		t.Fatalf("expected completed expression with result 5, got %+v", expr)
+	}
+}
+
+func TestDeleteExpression(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	deleteExpression := func(id string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/expressions/"+id, nil))
+		return w.Code
+	}
+
+	// Пока задача не посчитана, удалять нельзя
+	id := submitExpression(t, router, "2 + 2")
+	if status := deleteExpression(id); status != http.StatusConflict {
+		t.Fatalf("expected 409 for pending expression, got %d", status)
+	}
+
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":4}`)
+	if status := deleteExpression(id); status != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", status)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions/"+id, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", w.Code)
+	}
+	if status := deleteExpression(id); status != http.StatusNotFound {
+		t.Fatalf("expected 404 for repeated delete, got %d", status)
+	}
+}
+
+func TestDeleteExpressionsByStatus(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	deleteExpressions := func(query string) (int, map[string]int) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/expressions"+query, nil))
+		var body map[string]int
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	// Два посчитанных выражения и одно в очереди
+	for range 2 {
+		submitExpression(t, router, "2 + 2")
+		task := fetchTask(t, router)
+		submitResult(t, router, `{"id":"`+task.ID+`","result":4}`)
+	}
+	pending := submitExpression(t, router, "3 + 3")
+
+	for _, query := range []string{"", "?status=pending", "?status=processing"} {
+		if status, _ := deleteExpressions(query); status != http.StatusConflict {
+			t.Fatalf("expected 409 for %q, got %d", query, status)
+		}
+	}
+	if status, _ := deleteExpressions("?status=unknown"); status != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown status, got %d", status)
+	}
+
+	status, body := deleteExpressions("?status=completed")
+	if status != http.StatusOK || body["deleted"] != 2 {
+		t.Fatalf("expected 2 deleted expressions, got %d %v", status, body)
+	}
+	if status, body := deleteExpressions("?status=completed"); status != http.StatusOK || body["deleted"] != 0 {
+		t.Fatalf("expected nothing left to delete, got %d %v", status, body)
+	}
+
+	// Активное выражение не тронуто
+	if expr := getExpression(t, router, pending); expr.Status != "pending" {
+		t.Fatalf("expected pending expression to stay, got %+v", expr)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions", nil))
+	var list struct {
+		Total int `json:"total"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if list.Total != 1 {
+		t.Fatalf("expected 1 expression left, got %d", list.Total)
+	}
+}
+
+func TestCancelExpression(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	cancelExpression := func(id string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/expressions/"+id+"/cancel", nil))
+		return w.Code
+	}
+
+	// Отмена до того, как агент взял задачу: задача больше не выдаётся
+	id := submitExpression(t, router, "2 + 2")
+	if status := cancelExpression(id); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if expr := getExpression(t, router, id); expr.Status != "cancelled" {
+		t.Fatalf("expected status cancelled, got %s", expr.Status)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected no task for cancelled expression, got %d", w.Code)
+	}
+	if status := cancelExpression(id); status != http.StatusConflict {
+		t.Fatalf("expected 409 for repeated cancel, got %d", status)
+	}
+
+	// Посчитанное выражение отменить нельзя
+	completed := submitExpression(t, router, "3 * 3")
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":9}`)
+	if status := cancelExpression(completed); status != http.StatusConflict {
+		t.Fatalf("expected 409 for completed expression, got %d", status)
+	}
+	if status := cancelExpression("missing"); status != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", status)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	router := newApp(t).Handler()
+	for i := 0; i < 5; i++ {
+		submitExpression(t, router, "sqrt(4)")
+	}
+
+	all := listExpressions(t, router, "")
+	if all.Total != 5 || len(all.Expressions) != 5 {
+		t.Fatalf("expected 5 expressions, got %d of %d", len(all.Expressions), all.Total)
+	}
+
+	// Страницы идут подряд в том же порядке, что и полный список
+	var paged []application.Expression
+	for offset := 0; offset < 5; offset += 2 {
+		page := listExpressions(t, router, fmt.Sprintf("?limit=2&offset=%d", offset))
+		if page.Total != 5 {
+			t.Fatalf("expected total 5, got %d", page.Total)
+		}
+		paged = append(paged, page.Expressions...)
+	}
+	for i := range all.Expressions {
+		if paged[i].ID != all.Expressions[i].ID {
+			t.Fatalf("page order differs from full list at %d", i)
+		}
+	}
+	if page := listExpressions(t, router, "?offset=10"); len(page.Expressions) != 0 || page.Total != 5 {
+		t.Fatalf("expected empty page with total 5, got %d of %d", len(page.Expressions), page.Total)
+	}
+
+	for _, query := range []string{"?limit=abc", "?limit=0", "?limit=-1", "?offset=-1", "?offset=x"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestListStatusFilter(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	constant := submitExpression(t, router, "sqrt(9)")
+	completed := submitExpression(t, router, "1 + 1")
+	failed := submitExpression(t, router, "1 / 0")
+	pending := submitExpression(t, router, "2 * 2")
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":2}`)
+	task = fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","error":"division by zero"}`)
+
+	tests := []struct {
+		status string
+		ids    []string
+	}{
+		{"completed", []string{constant, completed}},
+		{"error", []string{failed}},
+		{"pending", []string{pending}},
+		{"cancelled", nil},
+	}
+	for _, test := range tests {
+		page := listExpressions(t, router, "?status="+test.status)
+		if page.Total != len(test.ids) {
+			t.Fatalf("%s: expected %d expressions, got %d", test.status, len(test.ids), page.Total)
+		}
+		for _, expr := range page.Expressions {
+			if expr.Status != test.status || !slices.Contains(test.ids, expr.ID) {
+				t.Fatalf("%s: unexpected expression %+v", test.status, expr)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions?status=done", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown status, got %d", w.Code)
+	}
+	var body map[string]string
+	json.NewDecoder(w.Body).Decode(&body)
+	if !strings.Contains(body["error"], "pending, processing, completed, error, cancelled") {
+		t.Fatalf("expected valid statuses in error, got %q", body["error"])
+	}
+}
+
+func TestExpressionTimestamps(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	before := time.Now()
+	id := submitExpression(t, router, "2 + 2")
+	created := getExpression(t, router, id)
+	if created.CreatedAt.Before(before.Add(-time.Second)) || created.CreatedAt.After(time.Now()) {
+		t.Fatalf("unexpected created_at %v", created.CreatedAt)
+	}
+	if !created.UpdatedAt.Equal(created.CreatedAt) {
+		t.Fatalf("expected updated_at == created_at for new expression, got %v and %v", created.UpdatedAt, created.CreatedAt)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":4}`)
+	completed := getExpression(t, router, id)
+	if !completed.CreatedAt.Equal(created.CreatedAt) || !completed.UpdatedAt.After(created.UpdatedAt) {
+		t.Fatalf("expected updated_at to move forward, got created %v updated %v", completed.CreatedAt, completed.UpdatedAt)
+	}
+
+	// В JSON время записано в RFC3339
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/expressions/"+id, nil))
+	var raw map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&raw)
+	for _, field := range []string{"created_at", "updated_at"} {
+		value, _ := raw[field].(string)
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			t.Fatalf("%s is not RFC3339: %q", field, value)
+		}
+	}
+}
+
+func TestListSortOrder(t *testing.T) {
+	router := newApp(t).Handler()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, submitExpression(t, router, "sqrt(4)"))
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// Порядок одинаков между запросами и совпадает с порядком создания
+	first := listExpressions(t, router, "")
+	second := listExpressions(t, router, "?sort=asc")
+	for i, id := range ids {
+		if first.Expressions[i].ID != id || second.Expressions[i].ID != id {
+			t.Fatalf("position %d: expected %s, got %s and %s", i, id, first.Expressions[i].ID, second.Expressions[i].ID)
+		}
+	}
+
+	desc := listExpressions(t, router, "?sort=desc")
+	for i, id := range ids {
+		if got := desc.Expressions[len(ids)-1-i].ID; got != id {
+			t.Fatalf("desc position %d: expected %s, got %s", len(ids)-1-i, id, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions?sort=random", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown sort, got %d", w.Code)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	router := newApp(t).Handler()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["status"] != "ok" {
+		t.Fatalf(`expected {"status":"ok"}, got %v (%v)`, body, err)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "calc.db"))
+	app := newApp(t)
+	router := app.Handler()
+
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+	if status := readyz(); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+
+	// База закрыта – трафик принимать нельзя
+	if err := app.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if status := readyz(); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 with closed store, got %d", status)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	submitExpression(t, router, "1 + 2")
+	submitExpression(t, router, "1 / 0")
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":3}`)
+	task = fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","error":"division by zero"}`)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"calc_expressions_submitted_total 2",
+		`calc_expressions{status="completed"} 1`,
+		`calc_expressions{status="error"} 1`,
+		"calc_task_queue_length 0",
+		"calc_division_by_zero_total 1",
+		`calc_task_results_total{outcome="ok",source="external"} 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("metrics have no %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("RESULT_CACHE_SIZE", "1")
+	router := newApp(t).Handler()
+
+	first := submitExpression(t, router, "2 + 3")
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":5}`)
+	if expr := getExpression(t, router, first); expr.Status != "completed" || expr.Result != 5 {
+		t.Fatalf("expected completed 5, got %s %v", expr.Status, expr.Result)
+	}
+
+	// Та же операция с теми же аргументами берётся из кэша: агенту выдаётся
+	// только умножение, а повтор выражения целиком считается сразу
+	id := submitExpression(t, router, "(2 + 3) * 4")
+	task = fetchTask(t, router)
+	if task.Operation != "*" || task.Arg1 != 5 || task.Arg2 != 4 {
+		t.Fatalf("expected task 5 * 4, got %v %s %v", task.Arg1, task.Operation, task.Arg2)
+	}
+	submitResult(t, router, `{"id":"`+task.ID+`","result":20}`)
+	if expr := getExpression(t, router, id); expr.Status != "completed" || expr.Result != 20 || expr.Progress != 100 {
+		t.Fatalf("expected completed 20, got %s %v (%v%%)", expr.Status, expr.Result, expr.Progress)
+	}
+	if expr := getExpression(t, router, submitExpression(t, router, "5 * 4")); expr.Status != "completed" || expr.Result != 20 {
+		t.Fatalf("expected cached 20, got %s %v", expr.Status, expr.Result)
+	}
+
+	// В кэше помещается один результат: 2 + 3 вытеснен умножением
+	submitExpression(t, router, "2 + 3")
+	if task := fetchTask(t, router); task.Operation != "+" {
+		t.Fatalf("expected evicted task +, got %s", task.Operation)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`calc_result_cache_lookups_total{outcome="hit"} 2`,
+		`calc_result_cache_lookups_total{outcome="miss"} 3`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Fatalf("metrics have no %q:\n%s", line, w.Body.String())
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	router := newApp(t).Handler()
+
+	// Новый ID генерируется для каждого запроса
+	first, second := httptest.NewRecorder(), httptest.NewRecorder()
+	router.ServeHTTP(first, httptest.NewRequest("GET", "/healthz", nil))
+	router.ServeHTTP(second, httptest.NewRequest("GET", "/api/v1/expressions/missing", nil))
+	firstID, secondID := first.Header().Get("X-Request-Id"), second.Header().Get("X-Request-Id")
+	if firstID == "" || secondID == "" || firstID == secondID {
+		t.Fatalf("expected distinct request IDs, got %q and %q", firstID, secondID)
+	}
+
+	// Входящий ID возвращается как есть
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("X-Request-Id", "client-trace-42")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-Id"); got != "client-trace-42" {
+		t.Fatalf("expected incoming request ID, got %q", got)
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	t.Setenv("API_KEY", "public-secret")
+	t.Setenv("INTERNAL_KEY", "agent-secret")
+	router := newApp(t).Handler()
+
+	request := func(method, path, key string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		method, path, key string
+		expected          int
+	}{
+		{"GET", "/api/v1/expressions", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/expressions", "wrong", http.StatusUnauthorized},
+		{"GET", "/api/v1/expressions", "agent-secret", http.StatusUnauthorized},
+		{"GET", "/api/v1/expressions", "public-secret", http.StatusOK},
+		{"GET", "/internal/task", "", http.StatusUnauthorized},
+		{"GET", "/internal/task", "public-secret", http.StatusUnauthorized},
+		{"GET", "/internal/task", "agent-secret", http.StatusNotFound},
+		{"GET", "/healthz", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		if status := request(test.method, test.path, test.key); status != test.expected {
+			t.Fatalf("%s %s with key %q: expected %d, got %d", test.method, test.path, test.key, test.expected, status)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Setenv("RATE_LIMIT_RPS", "2")
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	calculate := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression":"1+1"}`))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := calculate("10.0.0.1:1000"); w.Code != http.StatusCreated {
+			t.Fatalf("request %d: expected 201, got %d", i+1, w.Code)
+		}
+	}
+	w := calculate("10.0.0.1:2000")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+
+	// У другого клиента своя корзина
+	if w := calculate("10.0.0.2:1000"); w.Code != http.StatusCreated {
+		t.Fatalf("other client: expected 201, got %d", w.Code)
+	}
+
+	// Без API_KEY заголовок Authorization не проверяется, и смена его значения
+	// не даёт новой корзины
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression":"1+1"}`))
+		req.RemoteAddr = "10.0.0.1:3000"
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer random-%d", i))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("rotated Authorization %d: expected 429, got %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestMaxActiveExpressions(t *testing.T) {
+	t.Setenv("MAX_ACTIVE_EXPRESSIONS", "2")
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	calculate := func(remoteAddr, expression string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression":"`+expression+`"}`))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	ids := make([]string, 0, 2)
+	for _, expression := range []string{"1 + 1", "2 + 2"} {
+		w := calculate("10.0.0.1:1000", expression)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected 201, got %d", expression, w.Code)
+		}
+		var created map[string]string
+		json.NewDecoder(w.Body).Decode(&created)
+		ids = append(ids, created["id"])
+	}
+	if w := calculate("10.0.0.1:2000", "3 + 3"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the limit, got %d", w.Code)
+	}
+	if w := calculate("10.0.0.2:1000", "3 + 3"); w.Code != http.StatusCreated {
+		t.Fatalf("other client: expected 201, got %d", w.Code)
+	}
+
+	// Посчитанное выражение освобождает место
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task", nil))
+	var task application.Task
+	json.NewDecoder(w.Body).Decode(&task)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/internal/task", strings.NewReader(fmt.Sprintf(`{"id":%q,"result":2}`, task.ID))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("submit result: expected 200, got %d", w.Code)
+	}
+	if w := calculate("10.0.0.1:3000", "3 + 3"); w.Code != http.StatusCreated {
+		t.Fatalf("after completion: expected 201, got %d", w.Code)
+	}
+	if w := calculate("10.0.0.1:3000", "4 + 4"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 again, got %d", w.Code)
+	}
+
+	// Как и отменённое
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/expressions/"+ids[1]+"/cancel", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel: expected 200, got %d", w.Code)
+	}
+	if w := calculate("10.0.0.1:3000", "4 + 4"); w.Code != http.StatusCreated {
+		t.Fatalf("after cancel: expected 201, got %d", w.Code)
+	}
+}
+
+func TestRequestSizeLimits(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "1024")
+	t.Setenv("MAX_EXPRESSION_LENGTH", "50")
+	router := newApp(t).Handler()
+
+	calculate := func(body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+		return w.Code
+	}
+
+	long := strings.Repeat("1+", 30) + "1"
+	if status := calculate(`{"expression":"` + long + `"}`); status != http.StatusUnprocessableEntity {
+		t.Fatalf("long expression: expected 422, got %d", status)
+	}
+	huge := strings.Repeat("1+", 1000) + "1"
+	if status := calculate(`{"expression":"` + huge + `"}`); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("huge body: expected 413, got %d", status)
+	}
+	if status := calculate(`{"expression":"1+1"}`); status != http.StatusCreated {
+		t.Fatalf("small expression: expected 201, got %d", status)
+	}
+}
+
+func TestNestingDepthLimit(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+	calculate := func(expression string) (int, string) {
+		body, _ := json.Marshal(map[string]string{"expression": expression})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+	nested := func(n int) string {
+		return strings.Repeat("(", n) + "1 + 1" + strings.Repeat(")", n)
+	}
+
+	if status, body := calculate(nested(1000)); status != http.StatusUnprocessableEntity || !strings.Contains(body, "nested too deeply") {
+		t.Fatalf("deep expression: expected 422, got %d %s", status, body)
+	}
+	if status, _ := calculate(nested(50)); status != http.StatusCreated {
+		t.Fatalf("expression within the limit: expected 201, got %d", status)
+	}
+
+	t.Setenv("MAX_NESTING_DEPTH", "10")
+	router = newApp(t).Handler()
+	if status, _ := calculate(nested(50)); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 with MAX_NESTING_DEPTH=10, got %d", status)
+	}
+}
+
+func TestMaxOperand(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+	calculate := func(expression string) (int, string) {
+		body, _ := json.Marshal(map[string]string{"expression": expression})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	// По умолчанию ограничения нет
+	if status, _ := calculate("1e300 * 2"); status != http.StatusCreated {
+		t.Fatalf("expected 201 without MAX_OPERAND, got %d", status)
+	}
+
+	t.Setenv("MAX_OPERAND", "1000")
+	router = newApp(t).Handler()
+	tests := []struct {
+		expression string
+		status     int
+		message    string
+	}{
+		{"999 + 1", http.StatusCreated, ""},
+		{"-1000 * 1000", http.StatusCreated, ""},
+		{"1001 + 1", http.StatusUnprocessableEntity, "operand 1001 exceeds the limit of 1000"},
+		{"2 * (3 - -5000)", http.StatusUnprocessableEntity, "operand 5000 exceeds"},
+		{"1e4 / 2", http.StatusUnprocessableEntity, "operand 1e4 exceeds"},
+	}
+	for _, test := range tests {
+		status, body := calculate(test.expression)
+		if status != test.status || !strings.Contains(body, test.message) {
+			t.Errorf("%s: expected %d %q, got %d %s", test.expression, test.status, test.message, status, body)
+		}
+	}
+}
+
+func TestMaxTasksPerExpression(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("MAX_TASKS_PER_EXPRESSION", "3")
+	router := newApp(t).Handler()
+	post := func(path, expression string) (int, string) {
+		body, _ := json.Marshal(map[string]string{"expression": expression})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, bytes.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	tests := []struct {
+		expression string
+		status     int
+	}{
+		{"1 + 2 + 3 + 4", http.StatusCreated},
+		// Унарные операции и функции считает оркестратор, задачами они не считаются
+		{"-(1 + 2) * sqrt(4 + 5)", http.StatusCreated},
+		{"sqrt(16) + -abs(-2) * 3", http.StatusCreated},
+		{"1 + 2 + 3 + 4 + 5", http.StatusUnprocessableEntity},
+		{"(1 + 2) * (3 + 4)", http.StatusCreated},
+		{"(1 + 2) * (3 + 4) - 5", http.StatusUnprocessableEntity},
+	}
+	accepted := 0
+	for _, test := range tests {
+		status, body := post("/api/v1/calculate", test.expression)
+		if status != test.status {
+			t.Fatalf("%s: expected %d, got %d %s", test.expression, test.status, status, body)
+		}
+		if status == http.StatusCreated {
+			accepted++
+			continue
+		}
+		if !strings.Contains(body, "expression needs more than 3 tasks") {
+			t.Fatalf("%s: unexpected error %s", test.expression, body)
+		}
+		if status, _ := post("/api/v1/validate", test.expression); status != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: expected 422 from validate, got %d", test.expression, status)
+		}
+	}
+
+	// Отвергнутые выражения не сохранены и их задачи не поставлены
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions", nil))
+	var list struct {
+		Total int `json:"total"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if list.Total != accepted {
+		t.Fatalf("expected %d stored expressions, got %d", accepted, list.Total)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	var stats application.Stats
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	// Готовые задачи принятых выражений: 1 + 2; 1 + 2 и 4 + 5; -2 * 3; 1 + 2 и 3 + 4
+	if stats.QueueLen != 6 {
+		t.Fatalf("expected 6 queued tasks of accepted expressions, got %d", stats.QueueLen)
+	}
+}
+
+func TestExpressionVariables(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "calc.db"))
+	t.Setenv("COMPUTING_POWER", "0")
+	app := newApp(t)
+	router := app.Handler()
+	calculate := func(payload string) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(payload)))
+		return w.Code, w.Body.String()
+	}
+
+	status, body := calculate(`{"expression": "x * 2 + y", "vars": {"x": 4}}`)
+	if status != http.StatusUnprocessableEntity || !strings.Contains(body, `undefined variable \"y\"`) {
+		t.Fatalf("expected 422 for undefined variable, got %d %s", status, body)
+	}
+
+	status, body = calculate(`{"expression": "x * 2 + y", "vars": {"x": 3, "y": 4}}`)
+	if status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", status, body)
+	}
+	var created map[string]string
+	json.Unmarshal([]byte(body), &created)
+	if err := app.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	// Переменные сохраняются вместе с выражением и переживают перезапуск
+	router = newApp(t).Handler()
+	task := fetchTask(t, router)
+	if task.Operation != "*" || task.Arg1 != 3 || task.Arg2 != 2 {
+		t.Fatalf("expected 3 * 2 with substituted x, got %+v", task)
+	}
+	submitResult(t, router, `{"id":"`+task.ID+`","result":6}`)
+	task = fetchTask(t, router)
+	if task.Operation != "+" || task.Arg2 != 4 {
+		t.Fatalf("expected 6 + 4 with substituted y, got %+v", task)
+	}
+	submitResult(t, router, `{"id":"`+task.ID+`","result":10}`)
+
+	expr := getExpression(t, router, created["id"])
+	if expr.Status != "completed" || expr.Result != 10 || expr.Vars["x"] != 3 || expr.Vars["y"] != 4 {
+		t.Fatalf("expected completed expression with result 10 and its vars, got %+v", expr)
+	}
+}
+
+func TestBatchSubmit(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	w := httptest.NewRecorder()
+	body := `{"expressions":["2+2","(1+","3*3","sqrt(-1)"]}`
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate/batch", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		Results []application.BatchItem `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(resp.Results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(resp.Results))
+	}
+	for i, item := range resp.Results {
+		valid := i == 0 || i == 2
+		if item.Index != i || valid != (item.ID != "") || valid == (item.Error != "") {
+			t.Fatalf("unexpected result %d: %+v", i, item)
+		}
+	}
+
+	// Принятые выражения – обычные выражения со своими задачами
+	if expr := getExpression(t, router, resp.Results[2].ID); expr.Expression != "3*3" || expr.Status != "pending" {
+		t.Fatalf("unexpected batch expression: %+v", expr)
+	}
+	if page := listExpressions(t, router, ""); page.Total != 2 {
+		t.Fatalf("expected 2 stored expressions, got %d", page.Total)
+	}
+
+	for _, body := range []string{`{"expressions":[]}`, `{}`} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate/batch", strings.NewReader(body)))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: expected 422, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	calculate := func(key, expression string) (int, string) {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression":"`+expression+`"}`))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var created map[string]string
+		json.NewDecoder(w.Body).Decode(&created)
+		return w.Code, created["id"]
+	}
+
+	status, first := calculate("retry-1", "2 + 2")
+	if status != http.StatusCreated || first == "" {
+		t.Fatalf("expected 201 with id, got %d %q", status, first)
+	}
+	status, repeated := calculate("retry-1", "2 + 2")
+	if status != http.StatusCreated || repeated != first {
+		t.Fatalf("expected the same id %q on retry, got %d %q", first, status, repeated)
+	}
+	if page := listExpressions(t, router, ""); page.Total != 1 {
+		t.Fatalf("expected 1 expression after retry, got %d", page.Total)
+	}
+
+	if status, _ := calculate("retry-1", "3 + 3"); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for reused key with another expression, got %d", status)
+	}
+
+	// Отклонённое выражение не занимает ключ
+	if status, _ := calculate("retry-2", "(1 +"); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for invalid expression, got %d", status)
+	}
+	if status, id := calculate("retry-2", "1 + 1"); status != http.StatusCreated || id == first {
+		t.Fatalf("expected a new expression for released key, got %d %q", status, id)
+	}
+}
+
+func TestExpressionStream(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	id := submitExpression(t, router, "(1 + 2) * 3")
+	resp, err := http.Get(server.URL + "/api/v1/expressions/" + id + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %q", ct)
+	}
+
+	events := make(chan application.Expression)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var expr application.Expression
+			if err := json.Unmarshal([]byte(data), &expr); err == nil {
+				events <- expr
+			}
+		}
+	}()
+	next := func() application.Expression {
+		select {
+		case expr, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed unexpectedly")
+			}
+			return expr
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event in time")
+		}
+		return application.Expression{}
+	}
+
+	if expr := next(); expr.Status != "pending" {
+		t.Fatalf("expected pending first, got %s", expr.Status)
+	}
+	task := fetchTask(t, router)
+	if expr := next(); expr.Status != "processing" {
+		t.Fatalf("expected processing, got %s", expr.Status)
+	}
+	submitResult(t, router, `{"id":"`+task.ID+`","result":3}`)
+	task = fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":9}`)
+
+	var last application.Expression
+	for expr := range events {
+		last = expr
+	}
+	if last.Status != "completed" || last.Result != 9 {
+		t.Fatalf("expected stream to end with completed/9, got %s/%v", last.Status, last.Result)
+	}
+
+	missing, err := http.Get(server.URL + "/api/v1/expressions/missing/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", missing.StatusCode)
+	}
+}
+
+func TestCallbackURL(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	application.SetCallbackRetryDelay(10 * time.Millisecond)
+	router := newApp(t).Handler()
+
+	// Первая попытка доставки падает, вторая проходит
+	var attempts int
+	delivered := make(chan application.Expression, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		delivered <- decodeExpression(t, r.Body)
+	}))
+	defer receiver.Close()
+
+	w := httptest.NewRecorder()
+	body := `{"expression":"6 / 3","callback_url":"` + receiver.URL + `/done"}`
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":2}`)
+
+	select {
+	case expr := <-delivered:
+		if expr.Status != "completed" || expr.Result != 2 {
+			t.Fatalf("expected completed/2 in callback, got %s/%v", expr.Status, expr.Result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback was not delivered")
+	}
+
+	for _, url := range []string{"ftp://example.com/hook", "not a url", "/relative"} {
+		w := httptest.NewRecorder()
+		body := `{"expression":"1+1","callback_url":"` + url + `"}`
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(body)))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%q: expected 422, got %d", url, w.Code)
+		}
+	}
+}
+
+func TestTaskLongPoll(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	// Без задач запрос ждёт до таймаута и отвечает 404
+	start := time.Now()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task?wait=200ms", nil))
+	if w.Code != http.StatusNotFound || time.Since(start) < 200*time.Millisecond {
+		t.Fatalf("expected 404 after waiting, got %d after %v", w.Code, time.Since(start))
+	}
+
+	// Задача, появившаяся во время ожидания, отдаётся сразу
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task?wait=5s", nil))
+		done <- w
+	}()
+	time.Sleep(100 * time.Millisecond)
+	submitExpression(t, router, "2 + 2")
+	select {
+	case w := <-done:
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("long-poll did not return the new task")
+	}
+
+	for _, wait := range []string{"abc", "-1s", "10m"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task?wait="+wait, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("wait=%s: expected 400, got %d", wait, w.Code)
+		}
+	}
+}
+
+func TestAgentRegistry(t *testing.T) {
+	t.Setenv("AGENT_INACTIVE_AFTER", "100ms")
+	router := newApp(t).Handler()
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w
+	}
+	agents := func() map[string]application.AgentInfo {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/agents", nil))
+		var resp struct {
+			Agents []application.AgentInfo `json:"agents"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		byID := make(map[string]application.AgentInfo)
+		for _, agent := range resp.Agents {
+			byID[agent.ID] = agent
+		}
+		return byID
+	}
+
+	if w := post("/internal/register", `{"id":"agent-1","computing_power":4}`); w.Code != http.StatusOK {
+		t.Fatalf("register: expected 200, got %d", w.Code)
+	}
+	w := post("/internal/register", `{"computing_power":2}`)
+	var generated application.AgentInfo
+	json.NewDecoder(w.Body).Decode(&generated)
+	if generated.ID == "" {
+		t.Fatal("expected generated agent ID")
+	}
+
+	list := agents()
+	if agent := list["agent-1"]; agent.ComputingPower != 4 || agent.Status != "active" {
+		t.Fatalf("unexpected agent state: %+v", agent)
+	}
+
+	// Без heartbeat агент становится неактивным, heartbeat возвращает его
+	time.Sleep(150 * time.Millisecond)
+	if w := post("/internal/heartbeat", `{"id":"agent-1"}`); w.Code != http.StatusOK {
+		t.Fatalf("heartbeat: expected 200, got %d", w.Code)
+	}
+	list = agents()
+	if list["agent-1"].Status != "active" || list[generated.ID].Status != "inactive" {
+		t.Fatalf("expected agent-1 active and %s inactive, got %+v", generated.ID, list)
+	}
+
+	if w := post("/internal/heartbeat", `{"id":"unknown"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("heartbeat of unknown agent: expected 404, got %d", w.Code)
+	}
+}
+
+func TestVisibilityTimeout(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("VISIBILITY_TIMEOUT", "100ms")
+	app := newApp(t)
+	router := app.Handler()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.WatchLeases(ctx)
+
+	id := submitExpression(t, router, "2 + 3")
+	lost := fetchTask(t, router)
+
+	// Агент «упал»: результат не пришёл, и задача снова выдаётся
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task?wait=2s", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected task to be requeued, got %d", w.Code)
+	}
+	var task application.Task
+	json.NewDecoder(w.Body).Decode(&task)
+	if task.ID != lost.ID || task.Arg1 != 2 || task.Arg2 != 3 {
+		t.Fatalf("expected the same task back, got %+v (lost %+v)", task, lost)
+	}
+
+	body := fmt.Sprintf(`{"id":%q,"result":5}`, task.ID)
+	if code := submitResult(t, router, body); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	// Запоздавший результат первой выдачи уже не нужен
+	if code := submitResult(t, router, body); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for duplicate result, got %d", code)
+	}
+	if expr := getExpression(t, router, id); expr.Status != "completed" || expr.Result != 5 {
+		t.Fatalf("unexpected expression: %+v", expr)
+	}
+}
+
+func TestValidateExpression(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	tests := []struct {
+		body   string
+		status int
+		valid  bool
+	}{
+		{`{"expression":"2 + 2 * 2"}`, http.StatusOK, true},
+		{`{"expression":"(3 + 4"}`, http.StatusUnprocessableEntity, false},
+		{`{"expression":"sqrt(-4) + 1"}`, http.StatusUnprocessableEntity, false},
+		{`{"expression":"1 + 1","callback_url":"ftp://example.com"}`, http.StatusUnprocessableEntity, false},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/validate", strings.NewReader(test.body)))
+		var resp struct {
+			Valid bool   `json:"valid"`
+			Error string `json:"error"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != test.status || resp.Valid != test.valid || resp.Valid == (resp.Error != "") {
+			t.Fatalf("%s: unexpected response %d %+v", test.body, w.Code, resp)
+		}
+	}
+
+	// Проверка ничего не создаёт и не ставит задач
+	if page := listExpressions(t, router, ""); page.Total != 0 {
+		t.Fatalf("expected no expressions, got %d", page.Total)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected no tasks, got %d", w.Code)
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	app := newApp(t)
+	router := app.Handler()
+
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, d := range []time.Duration{100 * time.Millisecond, 300 * time.Millisecond} {
+		expr := &application.Expression{ID: fmt.Sprint("done-", i), Expression: "1 + 1", Status: "completed", CreatedAt: created, UpdatedAt: created.Add(d)}
+		if err := app.PutExpression(expr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	submitExpression(t, router, "2 + 2")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/stats", nil))
+	var stats application.Stats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if stats.Total != 3 || stats.ByStatus["completed"] != 2 || stats.ByStatus["pending"] != 1 || stats.ByStatus["error"] != 0 {
+		t.Fatalf("unexpected counts: %+v", stats)
+	}
+	if stats.AvgDurationMs != 200 || stats.QueueLen != 1 {
+		t.Fatalf("expected avg 200ms and one queued task, got %+v", stats)
+	}
+}
+
+func TestExpressionResult(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+	result := func(id string) (int, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions/"+id+"/result", nil))
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	id := submitExpression(t, router, "3 + 4")
+	if code, _ := result(id); code != http.StatusConflict {
+		t.Fatalf("pending expression: expected 409, got %d", code)
+	}
+	task := fetchTask(t, router)
+	if code, _ := result(id); code != http.StatusConflict {
+		t.Fatalf("processing expression: expected 409, got %d", code)
+	}
+	submitResult(t, router, fmt.Sprintf(`{"id":%q,"result":7}`, task.ID))
+	if code, body := result(id); code != http.StatusOK || body != `{"result":7}` {
+		t.Fatalf("completed expression: expected 200 {\"result\":7}, got %d %s", code, body)
+	}
+	if code, _ := result("missing"); code != http.StatusNotFound {
+		t.Fatalf("missing expression: expected 404, got %d", code)
+	}
+}
+
+func TestExpressionAccept(t *testing.T) {
+	app := newApp(t)
+	router := app.Handler()
+	startAgent(t, app)
+	id := submitExpression(t, router, "2 + 2")
+	waitForExpression(t, router, id)
+
+	tests := []struct {
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"", http.StatusOK, "application/json", `"result":4`},
+		{"application/json", http.StatusOK, "application/json", `"result":4`},
+		{"text/plain", http.StatusOK, "text/plain; charset=utf-8", "2 + 2 = 4 (completed)\n"},
+		{"text/*", http.StatusOK, "text/plain; charset=utf-8", "2 + 2 = 4 (completed)\n"},
+		{"text/plain;q=0.5, application/json", http.StatusOK, "application/json", `"result":4`},
+		{"application/json;q=0.1, text/plain", http.StatusOK, "text/plain; charset=utf-8", "2 + 2 = 4 (completed)\n"},
+		{"text/html, */*;q=0.8", http.StatusOK, "application/json", `"result":4`},
+		{"text/html", http.StatusNotAcceptable, "application/json", `"error"`},
+		{"application/json;q=0", http.StatusNotAcceptable, "application/json", `"error"`},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/api/v1/expressions/"+id, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Fatalf("Accept %q: expected status %d, got %d", test.accept, test.status, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+			t.Fatalf("Accept %q: expected Content-Type %q, got %q", test.accept, test.contentType, ct)
+		}
+		if !strings.Contains(w.Body.String(), test.body) {
+			t.Fatalf("Accept %q: expected body with %q, got %q", test.accept, test.body, w.Body.String())
+		}
+	}
+
+	failed := application.Expression{Expression: "1 / 0", Status: "error", Error: "division by zero"}
+	if text := failed.PlainText(); text != "1 / 0: division by zero (error)" {
+		t.Fatalf("unexpected text %q", text)
+	}
+}
+
+func TestRetryExpression(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+	retry := func(id string) (int, application.Expression) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v2/expressions/"+id+"/retry", nil))
+		if w.Code != http.StatusOK {
+			return w.Code, application.Expression{}
+		}
+		return w.Code, decodeExpression(t, w.Body)
+	}
+
+	// Временный сбой агента: задача вернулась с ошибкой
+	id := submitExpression(t, router, "(1 + 2) * 3")
+	task := fetchTask(t, router)
+	if code, _ := retry(id); code != http.StatusConflict {
+		t.Fatalf("retry of processing expression: expected 409, got %d", code)
+	}
+	submitResult(t, router, fmt.Sprintf(`{"id":%q,"error":"agent crashed"}`, task.ID))
+	failed := getExpression(t, router, id)
+	if failed.Status != "error" {
+		t.Fatalf("expected error status, got %q", failed.Status)
+	}
+
+	code, expr := retry(id)
+	if code != http.StatusOK || expr.Status != "pending" || expr.Error != "" || expr.CompletedTasks != 0 {
+		t.Fatalf("unexpected retry response %d %+v", code, expr)
+	}
+	if !expr.UpdatedAt.After(failed.UpdatedAt) {
+		t.Fatal("expected UpdatedAt to move forward")
+	}
+
+	// Выражение считается заново с первой задачи
+	task = fetchTask(t, router)
+	if task.Operation != "+" {
+		t.Fatalf("expected first task again, got %+v", task)
+	}
+	submitResult(t, router, fmt.Sprintf(`{"id":%q,"result":3}`, task.ID))
+	task = fetchTask(t, router)
+	submitResult(t, router, fmt.Sprintf(`{"id":%q,"result":9}`, task.ID))
+	if expr := getExpression(t, router, id); expr.Status != "completed" || expr.Result != 9 {
+		t.Fatalf("unexpected expression after retry: %+v", expr)
+	}
+
+	if code, _ := retry(id); code != http.StatusConflict {
+		t.Fatalf("retry of completed expression: expected 409, got %d", code)
+	}
+	if code, _ := retry("missing"); code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", code)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("DEDUPLICATE_EXPRESSIONS", "true")
+	router := newApp(t).Handler()
+
+	id := submitExpression(t, router, "2+2")
+	if again := submitExpression(t, router, " (2 + 2) "); again != id {
+		t.Fatalf("expected equal expression to reuse ID %s, got %s", id, again)
+	}
+	if other := submitExpression(t, router, "2 + 3"); other == id {
+		t.Fatal("different expression got the same ID")
+	}
+
+	// x ^ 2 при x = -3 – это 9, а -3 ^ 2 – это -9
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate",
+		strings.NewReader(`{"expression": "x ^ 2", "vars": {"x": -3}}`)))
+	var squared map[string]string
+	json.NewDecoder(w.Body).Decode(&squared)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if negated := submitExpression(t, router, "-3 ^ 2"); negated == squared["id"] {
+		t.Fatal("x ^ 2 with x = -3 and -3 ^ 2 got the same ID")
+	}
+
+	// Выражение, завершившееся ошибкой, повторно не отдаётся
+	failedID := submitExpression(t, router, "7 * 8")
+	task := fetchTask(t, router)
+	for task.Operation != "*" {
+		task = fetchTask(t, router)
+	}
+	submitResult(t, router, fmt.Sprintf(`{"id":%q,"error":"agent crashed"}`, task.ID))
+	if again := submitExpression(t, router, "7*8"); again == failedID {
+		t.Fatal("failed expression should not be reused")
+	}
+
+	// Удалённое выражение не отдаётся: равное создаётся заново и само
+	// становится записью для следующих
+	doneID := submitExpression(t, router, "5 - 1")
+	task = fetchTask(t, router)
+	for task.Operation != "-" {
+		task = fetchTask(t, router)
+	}
+	submitResult(t, router, fmt.Sprintf(`{"id":%q,"result":4}`, task.ID))
+	waitForExpression(t, router, doneID)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/expressions/"+doneID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", w.Code)
+	}
+	resubmitted := submitExpression(t, router, "5-1")
+	if resubmitted == doneID {
+		t.Fatal("deleted expression should not be reused")
+	}
+	if again := submitExpression(t, router, "5 - 1"); again != resubmitted {
+		t.Fatalf("expected resubmitted expression %s to be reused, got %s", resubmitted, again)
+	}
+
+	// Без DEDUPLICATE_EXPRESSIONS каждое выражение создаётся заново
+	t.Setenv("DEDUPLICATE_EXPRESSIONS", "")
+	router = newApp(t).Handler()
+	if submitExpression(t, router, "2 + 2") == submitExpression(t, router, "2 + 2") {
+		t.Fatal("expressions should not be deduplicated by default")
+	}
+}
+
+func TestDeduplicationQueueFull(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("DEDUPLICATE_EXPRESSIONS", "true")
+	t.Setenv("TASK_QUEUE_SIZE", "1")
+	t.Setenv("TASK_QUEUE_TIMEOUT_MS", "300")
+	router := newApp(t).Handler()
+	submitExpression(t, router, "1 + 1")
+
+	// Разные выражения ждут места в очереди одновременно, а не друг за другом
+	start := time.Now()
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i, expression := range []string{"2 + 2", "3 + 3"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression":"`+expression+`"}`)))
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+	for _, code := range codes {
+		if code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503, got %v", codes)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= 550*time.Millisecond {
+		t.Fatalf("submissions were serialized: took %v", elapsed)
+	}
+
+	// Отвергнутое выражение не остаётся в индексе: после освобождения места оно принимается
+	fetchTask(t, router)
+	if id := submitExpression(t, router, "2 + 2"); id == "" {
+		t.Fatal("expected expression to be accepted")
+	}
+}
+
+func TestConfigFromFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "port: \"9000\"\ncomputing_power: 3\ntask_queue_size: 10\ntime_addition_ms: 7\nstream_timeout: 30s\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Окружение переопределяет файл, флаги – окружение
+	t.Setenv("COMPUTING_POWER", "5")
+	t.Setenv("TASK_QUEUE_SIZE", "20")
+
+	config, err := application.ConfigFromFlags([]string{"--config", path, "--task-queue-size", "30"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Addr != "9000" || config.TimeAddition != 7 || config.StreamTimeout != 30*time.Second {
+		t.Fatalf("values from file not applied: %+v", config)
+	}
+	if config.ComputingPower != 5 {
+		t.Fatalf("expected env to override file, got computing power %d", config.ComputingPower)
+	}
+	if config.TaskQueueSize != 30 {
+		t.Fatalf("expected flag to override env, got queue size %d", config.TaskQueueSize)
+	}
+	if config.TimeDivision != 100 {
+		t.Fatalf("expected default for missing keys, got %d", config.TimeDivision)
+	}
+
+	if _, err := application.ConfigFromFlags([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Fatal("expected error for missing config file")
+	}
+
+	// Неположительные интервалы из файла отвергаются, а не роняют тикеры,
+	// как и нулевые пределы, без которых сервер не принял бы ни одного выражения
+	t.Setenv("COMPUTING_POWER", "")
+	t.Setenv("TASK_QUEUE_SIZE", "")
+	for _, data := range []string{
+		"visibility_timeout: 0s\n",
+		"janitor_interval: -5s\n",
+		"max_body_bytes: 0\n",
+		"max_nesting_depth: 0\n",
+		"task_queue_size: 0\n",
+		"max_tasks_per_expression: -1\n",
+		"computing_power: -1\n",
+	} {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		config, err := application.ConfigFromFlags([]string{"--config", path})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := application.NewWithConfig(config); err == nil {
+			t.Fatalf("%q: expected validation error", data)
+		}
+	}
+}
+
+func TestPortValidation(t *testing.T) {
+	for _, port := range []string{"abc", "0", "65536", "-1", "localhost:http", "1.2.3.4"} {
+		t.Setenv("PORT", port)
+		if _, err := application.New(); err == nil {
+			t.Fatalf("PORT=%q: expected error", port)
+		}
+	}
+
+	tests := []struct{ port, addr string }{
+		{"8080", ":8080"},
+		{"0.0.0.0:9000", "0.0.0.0:9000"},
+		{":9001", ":9001"},
+	}
+	for _, test := range tests {
+		t.Setenv("PORT", test.port)
+		config := application.ConfigFromEnv()
+		if err := config.Validate(); err != nil {
+			t.Fatalf("PORT=%q: unexpected error %v", test.port, err)
+		}
+		if addr := config.ListenAddr(); addr != test.addr {
+			t.Fatalf("PORT=%q: expected %q, got %q", test.port, test.addr, addr)
+		}
+	}
+}
+
+func TestExpressionTimeout(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("EXPRESSION_TIMEOUT", "100ms")
+	t.Setenv("JANITOR_INTERVAL", "20ms")
+	app := newApp(t)
+	router := app.Handler()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	old := time.Now().Add(-time.Hour).UTC()
+	done := &application.Expression{ID: "done", Expression: "1 + 1", Status: "completed", Result: 2, CreatedAt: old, UpdatedAt: old}
+	if err := app.PutExpression(done); err != nil {
+		t.Fatal(err)
+	}
+	id := submitExpression(t, router, "2 + 2")
+	task := fetchTask(t, router)
+	go app.RunJanitor(ctx)
+
+	expr := waitForExpression(t, router, id)
+	if expr.Status != "error" || expr.Error != "timeout" {
+		t.Fatalf("expected timeout error, got %s %q", expr.Status, expr.Error)
+	}
+	if code := submitResult(t, router, fmt.Sprintf(`{"id":%q,"result":4}`, task.ID)); code != http.StatusNotFound {
+		t.Fatalf("late result: expected 404, got %d", code)
+	}
+	if expr := getExpression(t, router, "done"); expr.Status != "completed" {
+		t.Fatalf("finished expression must stay completed, got %q", expr.Status)
+	}
+
+	// Перезапуск отсчитывает таймаут заново, а не от создания выражения
+	failed := &application.Expression{ID: "failed", Expression: "3 * 3", Status: "error", Error: "agent crashed", CreatedAt: old, UpdatedAt: old}
+	if err := app.PutExpression(failed); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/expressions/failed/retry", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("retry: expected 200, got %d", w.Code)
+	}
+	time.Sleep(50 * time.Millisecond)
+	task = fetchTask(t, router)
+	if code := submitResult(t, router, fmt.Sprintf(`{"id":%q,"result":9}`, task.ID)); code != http.StatusOK {
+		t.Fatalf("retried result: expected 200, got %d", code)
+	}
+	if expr := waitForExpression(t, router, "failed"); expr.Status != "completed" || expr.Result != 9 {
+		t.Fatalf("expected retried expression to complete with 9, got %s %v %q", expr.Status, expr.Result, expr.Error)
+	}
+}
+
+func TestExpressionTTL(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("EXPRESSION_TTL", "1h")
+	t.Setenv("JANITOR_INTERVAL", "20ms")
+	app := newApp(t)
+	router := app.Handler()
+
+	old := time.Now().Add(-2 * time.Hour).UTC()
+	fresh := time.Now().UTC()
+	for _, expr := range []*application.Expression{
+		{ID: "old-completed", Status: "completed", CreatedAt: old, UpdatedAt: old},
+		{ID: "old-error", Status: "error", CreatedAt: old, UpdatedAt: old},
+		{ID: "old-pending", Status: "pending", CreatedAt: old, UpdatedAt: old},
+		{ID: "fresh-completed", Status: "completed", CreatedAt: fresh, UpdatedAt: fresh},
+	} {
+		expr.Expression = "1 + 1"
+		if err := app.PutExpression(expr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.RunJanitor(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for listExpressions(t, router, "").Total != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected old finished expressions to be deleted, have %d", listExpressions(t, router, "").Total)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	var ids []string
+	for _, expr := range listExpressions(t, router, "").Expressions {
+		ids = append(ids, expr.ID)
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{"fresh-completed", "old-pending"}) {
+		t.Fatalf("unexpected remaining expressions %v", ids)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "calc_expressions_expired_total 2") {
+		t.Fatal("expected calc_expressions_expired_total 2 in metrics")
+	}
+}
+
+// startGRPC – gRPC-сервер приложения на свободном порту и клиент к нему
+func startGRPC(t *testing.T, app *application.Application) taskpb.TaskServiceClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := app.GRPCServer()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return taskpb.NewTaskServiceClient(conn)
+}
+
+func TestGRPCTaskStream(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("INTERNAL_KEY", "secret")
+	app := newApp(t)
+	router := app.Handler()
+	client := startGRPC(t, app)
+
+	// Без ключа стрим отклоняется
+	stream, err := client.Process(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret"))
+	defer cancel()
+	stream, err = client.Process(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := submitExpression(t, router, "(1 + 2) * 4")
+	for i := 0; i < 2; i++ {
+		task, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+		result := task.Arg1 + task.Arg2
+		if task.Operation == "*" {
+			result = task.Arg1 * task.Arg2
+		}
+		if err := stream.Send(&taskpb.Result{Id: task.Id, Result: result}); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	if expr := waitForExpression(t, router, id); expr.Status != "completed" || expr.Result != 12 {
+		t.Fatalf("unexpected expression %+v", expr)
+	}
+}
+
+func TestResultPrecision(t *testing.T) {
+	app := newApp(t)
+	router := app.Handler()
+	startAgent(t, app)
+	if expr := waitForExpression(t, router, submitExpression(t, router, "0.1 + 0.2")); expr.Result != 0.30000000000000004 {
+		t.Fatalf("expected no rounding by default, got %v", expr.Result)
+	}
+
+	t.Setenv("RESULT_PRECISION", "2")
+	app = newApp(t)
+	router = app.Handler()
+	startAgent(t, app)
+	tests := []struct {
+		expression string
+		result     float64
+	}{
+		{"0.1 + 0.2", 0.3},
+		{"2 / 3", 0.67},
+		{"sqrt(2)", 1.41},
+		{"1e20 * 3", 3e20},
+	}
+	for _, test := range tests {
+		if expr := waitForExpression(t, router, submitExpression(t, router, test.expression)); expr.Result != test.result {
+			t.Fatalf("%s: expected %v, got %v", test.expression, test.result, expr.Result)
+		}
+	}
+}
+
+func TestDivisionRounding(t *testing.T) {
+	t.Setenv("TIME_DIVISIONS_MS", "0")
+	t.Setenv("DIVISION_PRECISION", "2")
+	tests := []struct {
+		mode     string
+		positive float64
+		negative float64
+	}{
+		{"round", 3.33, -3.33},
+		{"floor", 3.33, -3.34},
+		{"ceil", 3.34, -3.33},
+		{"truncate", 3.33, -3.33},
+	}
+	for _, test := range tests {
+		t.Setenv("DIVISION_ROUNDING", test.mode)
+		app := newApp(t)
+		router := app.Handler()
+		startAgent(t, app)
+		for expression, want := range map[string]float64{"10 / 3": test.positive, "-10 / 3": test.negative, "0.29 / 1": 0.29} {
+			if expr := waitForExpression(t, router, submitExpression(t, router, expression)); expr.Result != want {
+				t.Errorf("%s, %s: expected %v, got %v", test.mode, expression, want, expr.Result)
+			}
+		}
+	}
+
+	// Результат внешнего агента округляется так же
+	t.Setenv("DIVISION_ROUNDING", "ceil")
+	t.Setenv("DIVISION_PRECISION", "0")
+	router := newApp(t).Handler()
+	id := submitExpression(t, router, "(10 / 3) * 2")
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":3.3333333333333335}`)
+	next := fetchTask(t, router)
+	if next.Arg1 != 4 {
+		t.Fatalf("expected rounded argument 4, got %+v", next)
+	}
+	submitResult(t, router, `{"id":"`+next.ID+`","result":8}`)
+	if expr := waitForExpression(t, router, id); expr.Result != 8 {
+		t.Fatalf("expected 8, got %v", expr.Result)
+	}
+
+	t.Setenv("DIVISION_ROUNDING", "banker")
+	if _, err := application.New(); err == nil {
+		t.Fatal("expected error for unknown DIVISION_ROUNDING")
+	}
+}
+
+func TestResultFormat(t *testing.T) {
+	// resultOf – запись итога выражения в ответе как есть
+	resultOf := func(router http.Handler, path string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %v, got %v", path, http.StatusOK, w.Code)
+		}
+		var body map[string]json.RawMessage
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return string(body["result"])
+	}
+
+	// Выражения без операций посчитаны сразу при приёме
+	t.Setenv("RESULT_FORMAT", "fixed")
+	router := newApp(t).Handler()
+	tests := []struct {
+		expression string
+		value      float64
+		fixed      string
+		auto       string
+	}{
+		{"0.0000001", 1e-7, "0.0000001", "1e-7"},
+		{"0.000000000000000000012345", 1.2345e-20, "0.000000000000000000012345", "1.2345e-20"},
+		{"10000000", 1e7, "10000000", "10000000"},
+		{"1e21", 1e21, "1000000000000000000000", "1e+21"},
+		{"-2.5e25", -2.5e25, "-25000000000000000000000000", "-2.5e+25"},
+	}
+	for _, test := range tests {
+		id := submitExpression(t, router, test.expression)
+		if got := resultOf(router, "/api/v1/expressions/"+id); got != test.fixed {
+			t.Errorf("%s: expected fixed %s, got %s", test.expression, test.fixed, got)
+		}
+		if got := resultOf(router, "/api/v1/expressions/"+id+"/result"); got != test.fixed {
+			t.Errorf("%s result: expected fixed %s, got %s", test.expression, test.fixed, got)
+		}
+		if got := resultOf(router, "/api/v1/expressions/"+id+"?format=auto"); got != test.auto {
+			t.Errorf("%s: expected auto %s, got %s", test.expression, test.auto, got)
+		}
+		// Запись остаётся числом
+		if expr := getExpression(t, router, id); expr.Result != test.value {
+			t.Errorf("%s: expected %v after decoding, got %v", test.expression, test.value, expr.Result)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/expressions?format=scientific", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format: expected status %v, got %v", http.StatusBadRequest, w.Code)
+	}
+
+	// С точностью fixed выводит ровно столько знаков
+	t.Setenv("RESULT_FORMAT", "auto")
+	t.Setenv("RESULT_PRECISION", "3")
+	router = newApp(t).Handler()
+	id := submitExpression(t, router, "0.0000001")
+	if got := resultOf(router, "/api/v1/expressions/"+id+"/result?format=fixed"); got != "0.000" {
+		t.Fatalf("expected 0.000, got %s", got)
+	}
+	id = submitExpression(t, router, "12345678.9")
+	if got := resultOf(router, "/api/v1/expressions/"+id+"/result?format=fixed"); got != "12345678.900" {
+		t.Fatalf("expected 12345678.900, got %s", got)
+	}
+
+	t.Setenv("RESULT_FORMAT", "scientific")
+	if _, err := application.New(); err == nil {
+		t.Fatal("expected error for unknown RESULT_FORMAT")
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	t.Setenv("API_KEY", "secret")
+	router := newApp(t).Handler()
+
+	tests := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{"PUT", "/api/v1/calculate", "POST"},
+		{"POST", "/api/v1/expressions/some-id", "GET, DELETE"},
+		{"DELETE", "/internal/task", "GET, POST"},
+		{"POST", "/healthz", "GET"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s %s: expected 405, got %d", test.method, test.path, w.Code)
+		}
+		if allow := w.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s: expected Allow %q, got %q", test.method, test.path, test.allow, allow)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: expected JSON body, got %q", test.method, test.path, ct)
+		}
+	}
+
+	for _, path := range []string{"/unknown", "/api/v1/unknown"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound || strings.TrimSpace(w.Body.String()) != `{"error":"not found"}` {
+			t.Fatalf("%s: expected JSON 404, got %d %s", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	t.Setenv("API_KEY", "secret")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://ui.example.com, https://admin.example.com")
+	router := newApp(t).Handler()
+
+	// Preflight проходит без ключа API
+	req := httptest.NewRequest("OPTIONS", "/api/v1/calculate", nil)
+	req.Header.Set("Origin", "https://ui.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: expected 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://ui.example.com" {
+		t.Fatalf("unexpected Access-Control-Allow-Origin %q", got)
+	}
+	if !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "Authorization") ||
+		!strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), "POST") {
+		t.Fatalf("unexpected preflight headers %v", w.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/expressions", nil)
+	req.Header.Set("Origin", "https://admin.example.com")
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://admin.example.com" {
+		t.Fatalf("expected CORS headers on regular response, got %d %v", w.Code, w.Header())
+	}
+
+	req = httptest.NewRequest("OPTIONS", "/api/v1/calculate", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("unexpected Access-Control-Allow-Origin for unknown origin")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	invalid := []map[string]string{
+		{"TLS_CERT": "cert.pem"},
+		{"TLS_KEY": "key.pem"},
+		{"HTTP_REDIRECT_PORT": "80"},
+		{"TLS_CERT": "cert.pem", "TLS_KEY": "key.pem", "HTTP_REDIRECT_PORT": "http"},
+	}
+	for _, env := range invalid {
+		t.Run(fmt.Sprint(env), func(t *testing.T) {
+			for name, value := range env {
+				t.Setenv(name, value)
+			}
+			if err := application.ConfigFromEnv().Validate(); err == nil {
+				t.Fatalf("expected error for %v", env)
+			}
+		})
+	}
+
+	tests := []struct{ tlsAddr, url, location string }{
+		{"8443", "http://calc.example.com/api/v1/expressions?limit=1", "https://calc.example.com:8443/api/v1/expressions?limit=1"},
+		{"0.0.0.0:443", "http://calc.example.com:8080/healthz", "https://calc.example.com/healthz"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		application.HTTPSRedirect(test.tlsAddr).ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != test.location {
+			t.Fatalf("%s: expected redirect to %s, got %d %q", test.url, test.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	app := newApp(t)
+	router := app.Handler()
+
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	done := &application.Expression{ID: "done", Expression: "1 + 1", Status: "completed", Result: 2, CreatedAt: created, UpdatedAt: created}
+	if err := app.PutExpression(done); err != nil {
+		t.Fatal(err)
+	}
+	pendingID := submitExpression(t, router, "2 * 3")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/export", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") {
+		t.Fatalf("expected attachment, got Content-Disposition %q", cd)
+	}
+	var export application.Export
+	if err := json.NewDecoder(w.Body).Decode(&export); err != nil {
+		t.Fatalf("export is not valid JSON: %v", err)
+	}
+	if export.ExportedAt.IsZero() || len(export.Expressions) != 2 {
+		t.Fatalf("unexpected export: %+v", export)
+	}
+	if first, second := export.Expressions[0], export.Expressions[1]; first.ID != "done" || first.Result != 2 || second.ID != pendingID {
+		t.Fatalf("expected expressions in creation order, got %+v", export.Expressions)
+	}
+
+	// Пустое хранилище выгружается пустым массивом
+	w = httptest.NewRecorder()
+	newApp(t).Handler().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/export", nil))
+	if err := json.NewDecoder(w.Body).Decode(&export); err != nil || export.Expressions == nil || len(export.Expressions) != 0 {
+		t.Fatalf("expected empty expressions array, got %v, %+v", err, export)
+	}
+}
+
+func TestImport(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	source := newApp(t)
+	if err := source.PutExpression(&application.Expression{ID: "done", Expression: "1 + 1", Status: "completed", Result: 2}); err != nil {
+		t.Fatal(err)
+	}
+	submitExpression(t, source.Handler(), "2 * 3")
+	w := httptest.NewRecorder()
+	source.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/export", nil))
+	var backup application.Export
+	if err := json.NewDecoder(w.Body).Decode(&backup); err != nil {
+		t.Fatal(err)
+	}
+	backup.Expressions = append(backup.Expressions,
+		application.Expression{ID: "broken", Expression: "2 +", Status: "pending"},
+		application.Expression{ID: "unknown", Expression: "1", Status: "unknown"},
+	)
+	data, _ := json.Marshal(backup)
+
+	importBackup := func(router http.Handler, query string) application.ImportReport {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/import"+query, bytes.NewReader(data)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		var report application.ImportReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("invalid report: %v", err)
+		}
+		return report
+	}
+
+	target := newApp(t)
+	router := target.Handler()
+	report := importBackup(router, "?keep_ids=true")
+	if report.Imported != 2 || report.Skipped != 2 || report.Results[2].Error == "" || report.Results[3].Error == "" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if expr := getExpression(t, router, "done"); expr.Status != "completed" || expr.Result != 2 {
+		t.Fatalf("completed expression not restored: %+v", expr)
+	}
+	// Незавершённое выражение снова в очереди
+	pendingID := report.Results[1].ID
+	task := fetchTask(t, router)
+	submitResult(t, router, fmt.Sprintf(`{"id":%q,"result":6}`, task.ID))
+	if expr := getExpression(t, router, pendingID); expr.Status != "completed" || expr.Result != 6 {
+		t.Fatalf("pending expression not recalculated: %+v", expr)
+	}
+
+	// Повторный импорт с теми же ID пропускает занятые, без keep_ids – создаёт новые
+	if report := importBackup(router, "?keep_ids=true"); report.Imported != 0 {
+		t.Fatalf("expected existing IDs to be skipped, got %+v", report)
+	}
+	report = importBackup(router, "")
+	if report.Imported != 2 || report.Results[0].ID == "done" {
+		t.Fatalf("expected new IDs, got %+v", report)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	prevPropagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("POST", "/api/v1/calculate", strings.NewReader(`{"expression":"2 + 3"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	// Контекст постановки задачи приходит агенту в заголовке ответа
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/internal/task", nil))
+	var task application.Task
+	json.NewDecoder(w.Body).Decode(&task)
+	traceparent := w.Header().Get("traceparent")
+	if !strings.HasPrefix(traceparent, "00-"+traceID+"-") {
+		t.Fatalf("expected task traceparent in trace %s, got %q", traceID, traceparent)
+	}
+
+	// Агент отправляет результат в контексте своего span обработки задачи
+	agentSpan := "b7ad6b7169203331"
+	req = httptest.NewRequest("POST", "/internal/task", strings.NewReader(`{"id":"`+task.ID+`","result":5}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-"+agentSpan+"-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		// Запрос задачи агентом начинает свою трассировку
+		if span.Name() != "GET /internal/task" && span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("span %q is in trace %s, expected %s", span.Name(), span.SpanContext().TraceID(), traceID)
+		}
+	}
+	calculate, submit, result := spans["POST /api/v1/calculate"], spans["submit expression"], spans["POST /internal/task"]
+	if calculate == nil || submit == nil || result == nil || spans["GET /internal/task"] == nil {
+		t.Fatalf("expected HTTP and submit spans, got %v", slices.Collect(maps.Keys(spans)))
+	}
+	if calculate.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("calculate span should continue incoming trace, parent %s", calculate.Parent().SpanID())
+	}
+	if submit.Parent().SpanID() != calculate.SpanContext().SpanID() {
+		t.Errorf("submit span should be a child of the HTTP span")
+	}
+	if !strings.Contains(traceparent, submit.SpanContext().SpanID().String()) {
+		t.Errorf("task should carry the submit span context, got %q", traceparent)
+	}
+	if result.Parent().SpanID().String() != agentSpan {
+		t.Errorf("result span should be a child of the agent span, parent %s", result.Parent().SpanID())
+	}
+}
+
+func TestAPIVersions(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "0")
+	router := newApp(t).Handler()
+	get := func(path string) map[string]json.RawMessage {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, w.Code)
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: invalid response: %v", path, err)
+		}
+		return body
+	}
+
+	// Маршруты /api/v2 принимают выражения так же, как /api/v1
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v2/calculate", strings.NewReader(`{"expression": "2 - 2"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 from /api/v2/calculate, got %d", w.Code)
+	}
+	var created map[string]string
+	json.Unmarshal(w.Body.Bytes(), &created)
+	id := created["id"]
+
+	// Пока выражение не посчитано, в v2 итог – null
+	if v2 := get("/api/v2/expressions/" + id); string(v2["result"]) != "null" {
+		t.Fatalf("expected null result in v2, got %s", v2["result"])
+	}
+	task := fetchTask(t, router)
+	submitResult(t, router, `{"id":"`+task.ID+`","result":0}`)
+
+	// v1 сохраняет исходный набор полей: нулевой итог не выводится, а полей,
+	// добавленных позже, нет
+	v1 := get("/api/v1/expressions/" + id)
+	fields := slices.Sorted(maps.Keys(v1))
+	expected := []string{"expression", "id", "status"}
+	if !slices.Equal(fields, expected) {
+		t.Fatalf("expected v1 fields %v, got %v", expected, fields)
+	}
+	for _, key := range []string{"error", "progress", "total_tasks", "completed_tasks", "created_at", "updated_at", "callback_url", "priority", "vars"} {
+		if _, found := v1[key]; found {
+			t.Fatalf("v1 should not have %q", key)
+		}
+	}
+
+	v2 := get("/api/v2/expressions/" + id)
+	if string(v2["result"]) != "0" {
+		t.Fatalf("expected zero result in v2, got %s", v2["result"])
+	}
+	var progress struct {
+		CompletedTasks int     `json:"completed_tasks"`
+		TotalTasks     int     `json:"total_tasks"`
+		Percent        float64 `json:"percent"`
+	}
+	if err := json.Unmarshal(v2["progress"], &progress); err != nil || progress.CompletedTasks != 1 || progress.TotalTasks != 1 || progress.Percent != 100 {
+		t.Fatalf("unexpected v2 progress %s", v2["progress"])
+	}
+	if _, found := v2["total_tasks"]; found {
+		t.Fatal("v2 should not have top-level total_tasks")
+	}
+
+	var page struct {
+		Expressions []map[string]json.RawMessage `json:"expressions"`
+	}
+	json.Unmarshal(get("/api/v2/expressions")["expressions"], &page.Expressions)
+	if len(page.Expressions) != 1 || string(page.Expressions[0]["result"]) != "0" {
+		t.Fatalf("expected v2 list with zero result, got %v", page.Expressions)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Setenv("API_KEY", "secret")
+	router := newApp(t).Handler()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&spec); err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+	// Все маршруты /api/v1 должны быть описаны
+	routes := map[string][]string{
+		"/api/v1/calculate":               {"post"},
+		"/api/v1/calculate/batch":         {"post"},
+		"/api/v1/validate":                {"post"},
+		"/api/v1/expressions":             {"get"},
+		"/api/v1/expressions/{id}":        {"get", "delete"},
+		"/api/v1/expressions/{id}/result": {"get"},
+		"/api/v1/expressions/{id}/cancel": {"post"},
+		"/api/v1/expressions/{id}/retry":  {"post"},
+		"/api/v1/expressions/{id}/stream": {"get"},
+		"/api/v1/stats":                   {"get"},
+		"/api/v1/export":                  {"get"},
+		"/api/v1/import":                  {"post"},
+	}
+	for path, methods := range routes {
+		for _, method := range methods {
+			if _, ok := spec.Paths[path][method]; !ok {
+				t.Errorf("%s %s is not documented", strings.ToUpper(method), path)
+			}
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/openapi.json") {
+		t.Fatalf("unexpected /docs response %d", w.Code)
+	}
 }
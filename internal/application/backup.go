@@ -0,0 +1,158 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Export – документ GET /api/v1/export: все выражения на момент ExportedAt
+type Export struct {
+	ExportedAt  time.Time    `json:"exported_at"`
+	Expressions []Expression `json:"expressions"`
+}
+
+// ExportHandler – выгрузка всех выражений одним JSON-документом Export для
+// бэкапа или переноса на другой экземпляр. Документ пишется в ответ по одному
+// выражению, не собираясь целиком в памяти. Итоги пишутся без учёта
+// ResultFormat, чтобы бэкап не терял точность
+func (a *Application) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="expressions-%s.json"`, now.Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeExport(w, now, a.store); err != nil {
+		// Заголовки уже отправлены – остаётся только записать в журнал
+		loggerFrom(r.Context()).Error("ошибка при выгрузке выражений", "error", err)
+	}
+}
+
+// writeExport – запись документа Export в w; выражения читаются из store
+// и пишутся по одному
+func writeExport(w io.Writer, exportedAt time.Time, store Store) error {
+	enc := json.NewEncoder(w)
+	if _, err := io.WriteString(w, `{"exported_at":`); err != nil {
+		return err
+	}
+	if err := enc.Encode(exportedAt); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"expressions":[`); err != nil {
+		return err
+	}
+	first := true
+	err := store.Each(func(expr Expression) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		return enc.Encode(expr)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// ImportItem – итог загрузки одного выражения: ID, под которым оно сохранено,
+// или причина, по которой оно пропущено
+type ImportItem struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ImportReport – ответ POST /api/v1/import
+type ImportReport struct {
+	Imported int          `json:"imported"`
+	Skipped  int          `json:"skipped"`
+	Results  []ImportItem `json:"results"`
+}
+
+// ImportHandler – загрузка выражений из документа в формате GET /api/v1/export.
+// По умолчанию выражения получают новые ID; с ?keep_ids=true сохраняют прежние,
+// а выражение с уже занятым ID пропускается. Каждое выражение проверяется, как
+// при приёме; незавершённые ("pending", "processing") раскладываются на задачи
+// заново. Невалидные элементы не мешают остальным
+func (a *Application) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	keepIDs := false
+	if value := r.URL.Query().Get("keep_ids"); value != "" {
+		var err error
+		if keepIDs, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid keep_ids, expected true or false")
+			return
+		}
+	}
+	var doc Export
+	if !a.readJSON(w, r, &doc, "invalid import payload") {
+		return
+	}
+
+	report := ImportReport{Results: make([]ImportItem, 0, len(doc.Expressions))}
+	for i, expr := range doc.Expressions {
+		item := ImportItem{Index: i}
+		if err := a.importExpression(&expr, keepIDs); err != nil {
+			item.Error = err.Error()
+			report.Skipped++
+		} else {
+			item.ID = expr.ID
+			report.Imported++
+		}
+		report.Results = append(report.Results, item)
+	}
+	loggerFrom(r.Context()).Info("выражения импортированы", "imported", report.Imported, "skipped", report.Skipped)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// importExpression – проверка и сохранение одного импортируемого выражения
+func (a *Application) importExpression(expr *Expression, keepID bool) error {
+	if !slices.Contains(expressionStatuses, expr.Status) {
+		return fmt.Errorf("invalid status %q", expr.Status)
+	}
+	tree, rejected := a.checkRequest(Request{Expression: expr.Expression, CallbackURL: expr.CallbackURL, Vars: expr.Vars})
+	if rejected != nil {
+		return rejected
+	}
+	if keepID {
+		if expr.ID == "" {
+			return fmt.Errorf("expression has no id")
+		}
+		if _, found := a.store.Get(expr.ID); found {
+			return fmt.Errorf("expression %s already exists", expr.ID)
+		}
+	} else {
+		expr.ID = generateUniqueID()
+	}
+
+	now := time.Now().UTC()
+	if expr.CreatedAt.IsZero() {
+		expr.CreatedAt = now
+	}
+	if expr.UpdatedAt.IsZero() {
+		expr.UpdatedAt = now
+	}
+	unfinished := expr.Status == "pending" || expr.Status == "processing"
+	if unfinished {
+		if err := a.graph.check(tree, a.config); err != nil {
+			return buildError(err)
+		}
+		expr.Status = "pending"
+	}
+	if err := a.store.Add(expr); err != nil {
+		slog.Error("ошибка при сохранении выражения", "expression_id", expr.ID, "error", err)
+		return fmt.Errorf("failed to store expression")
+	}
+	if unfinished {
+		return a.restartExpression(expr.ID, expr.Expression, expr.Vars, expr.Priority)
+	}
+	return nil
+}
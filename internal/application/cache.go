@@ -0,0 +1,88 @@
+package application
+
+import (
+	"container/list"
+	"math"
+	"sync"
+)
+
+// resultKey – ключ кэша результатов: операция и её аргументы. Числа
+// сравниваются побитово, чтобы 0 и -0 не смешивались
+type resultKey struct {
+	arg1, arg2 uint64
+	op         string
+}
+
+func resultKeyOf(task Task) resultKey {
+	return resultKey{arg1: math.Float64bits(task.Arg1), arg2: math.Float64bits(task.Arg2), op: task.Operation}
+}
+
+// resultEntry – элемент списка LRU
+type resultEntry struct {
+	key    resultKey
+	result float64
+}
+
+// resultCache – LRU-кэш результатов задач. Задача, уже посчитанная с теми же
+// аргументами, не выдаётся агенту повторно. Кэшируются только успешные результаты
+type resultCache struct {
+	mu       sync.Mutex
+	capacity int
+	// order – элементы от недавно использованных к давно не использованным
+	order   *list.List
+	entries map[resultKey]*list.Element
+}
+
+func newResultCache(capacity int) *resultCache {
+	return &resultCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[resultKey]*list.Element),
+	}
+}
+
+// get – результат задачи с такими же операцией и аргументами
+func (c *resultCache) get(task Task) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, found := c.entries[resultKeyOf(task)]
+	if !found {
+		return 0, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*resultEntry).result, true
+}
+
+// add – запоминание результата; при переполнении вытесняется давно не использованный
+func (c *resultCache) add(task Task, result float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := resultKeyOf(task)
+	if elem, found := c.entries[key]; found {
+		elem.Value.(*resultEntry).result = result
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&resultEntry{key: key, result: result})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*resultEntry).key)
+	}
+}
+
+// completeFromCache – учёт задачи, результат которой уже есть в кэше, без
+// выдачи агенту. false – задачу нужно посчитать
+func (a *Application) completeFromCache(task Task) bool {
+	if a.results == nil {
+		return false
+	}
+	result, found := a.results.get(task)
+	if !found {
+		a.metrics.resultCache.WithLabelValues("miss").Inc()
+		return false
+	}
+	a.metrics.resultCache.WithLabelValues("hit").Inc()
+	a.completeTask(Result{ID: task.ID, Result: result})
+	return true
+}
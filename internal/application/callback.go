@@ -0,0 +1,73 @@
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// callbackAttempts – сколько раз пытаться доставить колбэк
+	callbackAttempts = 3
+	// callbackTimeout – таймаут одной попытки доставки
+	callbackTimeout = 5 * time.Second
+)
+
+// callbackRetryDelay – пауза перед второй попыткой, дальше она удваивается
+var callbackRetryDelay = time.Second
+
+// validateCallbackURL – колбэк допустим только на абсолютный http/https URL
+func validateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback_url: expected http or https URL")
+	}
+	return nil
+}
+
+// deliverCallback – POST итогового состояния выражения на его callback_url
+// в отдельной горутине. Вызывается после простановки "completed" или "error"
+func (a *Application) deliverCallback(id string) {
+	expr, found := a.store.Get(id)
+	if !found || expr.CallbackURL == "" || (expr.Status != "completed" && expr.Status != "error") {
+		return
+	}
+	a.callbacks.Add(1)
+	go func() {
+		defer a.callbacks.Done()
+		if err := postCallback(a.viewV2(expr, a.config.ResultFormat)); err != nil {
+			slog.Warn("не удалось доставить колбэк", "expression_id", expr.ID, "status", expr.Status,
+				"callback_url", expr.CallbackURL, "error", err)
+		}
+	}()
+}
+
+// postCallback – доставка с ретраями; успех – любой ответ 2xx
+func postCallback(expr expressionV2) error {
+	body, err := json.Marshal(expr)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: callbackTimeout}
+	delay := callbackRetryDelay
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Post(expr.CallbackURL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		if attempt == callbackAttempts {
+			return fmt.Errorf("после %d попыток: %w", attempt, err)
+		}
+		slog.Debug("повтор доставки колбэка", "expression_id", expr.ID, "attempt", attempt, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
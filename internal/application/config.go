@@ -0,0 +1,470 @@
+package application
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultOperationTime – время выполнения операции по умолчанию, мс
+	defaultOperationTime = 100
+	// defaultTaskQueueSize – размер очереди задач по умолчанию
+	defaultTaskQueueSize = 100
+	// defaultTaskQueueTimeout – ожидание места в очереди по умолчанию, мс
+	defaultTaskQueueTimeout = 5000
+	// defaultDBPath – файл базы SQLite по умолчанию
+	defaultDBPath = "calc.db"
+	// defaultMaxBodyBytes – предельный размер тела запроса по умолчанию (1 МБ)
+	defaultMaxBodyBytes = 1 << 20
+	// defaultMaxExpressionLength – предельная длина выражения по умолчанию
+	defaultMaxExpressionLength = 10000
+	// defaultMaxTasksPerExpression – предельное число задач одного выражения по умолчанию
+	defaultMaxTasksPerExpression = 1000
+	// defaultIdempotencyTTL – сколько помнить Idempotency-Key по умолчанию
+	defaultIdempotencyTTL = 24 * time.Hour
+	// defaultStreamTimeout – предельная длительность SSE-стрима по умолчанию
+	defaultStreamTimeout = 5 * time.Minute
+	// defaultVisibilityTimeout – сколько ждать результат выданной задачи по умолчанию
+	defaultVisibilityTimeout = time.Minute
+	// defaultJanitorInterval – период фоновой уборки хранилища по умолчанию
+	defaultJanitorInterval = 10 * time.Second
+	// defaultAgentInactiveAfter – через сколько без heartbeat агент неактивен
+	defaultAgentInactiveAfter = 30 * time.Second
+)
+
+// Config – конфигурация приложения. Теги yaml – ключи файла конфигурации;
+// длительности в файле записываются строкой ("5s", "24h")
+type Config struct {
+	// Addr – порт (1..65535) или полный адрес прослушивания ("0.0.0.0:8080")
+	Addr string `yaml:"port"`
+	// GRPCAddr – порт или адрес gRPC-сервера для агентов; пустой – gRPC выключен
+	GRPCAddr string `yaml:"grpc_port"`
+
+	// TLSCert и TLSKey – файлы сертификата и ключа; если заданы оба, сервер
+	// слушает HTTPS. HTTPRedirectAddr – порт или адрес, на котором HTTP-запросы
+	// перенаправляются на HTTPS; пустой – без перенаправления
+	TLSCert          string `yaml:"tls_cert"`
+	TLSKey           string `yaml:"tls_key"`
+	HTTPRedirectAddr string `yaml:"http_redirect_port"`
+
+	// DBPath – путь к базе SQLite; пустой путь – хранение только в памяти
+	DBPath string `yaml:"db_path"`
+
+	// APIKey – ключ для /api/v1/*, InternalKey – для /internal/* (его знает
+	// только агент); пустой ключ отключает проверку
+	APIKey      string `yaml:"api_key"`
+	InternalKey string `yaml:"internal_key"`
+
+	// CORSAllowedOrigins – origin, которым браузер разрешит обращаться к API;
+	// "*" – любым, пустой список – CORS выключен
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins"`
+
+	// MaxBodyBytes – предельный размер тела запроса, больше – 413
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// MaxExpressionLength – предельная длина строки выражения, больше – 422
+	MaxExpressionLength int `yaml:"max_expression_length"`
+	// MaxNestingDepth – предельная глубина вложенности скобок и операций, больше – 422
+	MaxNestingDepth int `yaml:"max_nesting_depth"`
+	// MaxOperand – предел абсолютного значения каждого числа в выражении,
+	// больше – 422; 0 – без ограничения
+	MaxOperand float64 `yaml:"max_operand"`
+	// MaxTasksPerExpression – сколько задач для агентов может дать одно
+	// выражение, больше – 422; 0 – без ограничения
+	MaxTasksPerExpression int `yaml:"max_tasks_per_expression"`
+
+	// IdempotencyTTL – сколько повтор запроса с тем же Idempotency-Key возвращает прежний ID
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl"`
+	// VisibilityTimeout – через сколько задача, выданная внешнему агенту без
+	// ответа, возвращается в очередь
+	VisibilityTimeout time.Duration `yaml:"visibility_timeout"`
+	// ExpressionTimeout – через сколько после запуска или перезапуска
+	// непосчитанное выражение завершается ошибкой "timeout"; 0 – без ограничения
+	ExpressionTimeout time.Duration `yaml:"expression_timeout"`
+	// ExpressionTTL – через сколько после последнего изменения завершённое
+	// выражение удаляется из хранилища; 0 – хранить всегда
+	ExpressionTTL time.Duration `yaml:"expression_ttl"`
+	// JanitorInterval – период фоновой уборки хранилища
+	JanitorInterval time.Duration `yaml:"janitor_interval"`
+	// AgentInactiveAfter – через сколько без heartbeat внешний агент считается неактивным
+	AgentInactiveAfter time.Duration `yaml:"agent_inactive_after"`
+	// StreamTimeout – через сколько закрывать SSE-стрим, даже если выражение не посчитано
+	StreamTimeout time.Duration `yaml:"stream_timeout"`
+
+	// DeduplicateExpressions – выражение, равное после нормализации уже
+	// принятому и не завершившемуся ошибкой, не создаётся заново: клиент
+	// получает ID существующего
+	DeduplicateExpressions bool `yaml:"deduplicate_expressions"`
+	// ResultCacheSize – сколько результатов задач помнить, чтобы не выдавать
+	// агентам уже посчитанные операции с теми же аргументами; 0 – кэш выключен
+	ResultCacheSize int `yaml:"result_cache_size"`
+
+	// RateLimitRPS – сколько выражений в секунду может прислать один клиент; 0 – без ограничения
+	RateLimitRPS int `yaml:"rate_limit_rps"`
+	// MaxActiveExpressions – сколько выражений одного клиента может быть в
+	// статусе "pending" или "processing"; 0 – без ограничения
+	MaxActiveExpressions int `yaml:"max_active_expressions"`
+
+	// ComputingPower – число встроенных агентов; 0 – только внешние агенты
+	ComputingPower int `yaml:"computing_power"`
+
+	// TaskQueueSize – размер буфера очереди задач (читается один раз при старте)
+	TaskQueueSize int `yaml:"task_queue_size"`
+	// TaskQueueTimeout – сколько ждать места в заполненной очереди
+	TaskQueueTimeout time.Duration `yaml:"task_queue_timeout"`
+
+	// ResultPrecision – до скольких знаков после запятой округлять итог
+	// выражения; отрицательное значение – без округления
+	ResultPrecision int `yaml:"result_precision"`
+	// ResultFormat – запись итога в ответах: "auto" (как в JSON, 1e-7) или
+	// "fixed" (десятичная с ResultPrecision знаками); запрос может выбрать
+	// другой параметром format
+	ResultFormat string `yaml:"result_format"`
+	// DivisionPrecision – до скольких знаков после запятой округлять результат
+	// каждого деления; отрицательное значение – без округления
+	DivisionPrecision int `yaml:"division_precision"`
+	// DivisionRounding – режим этого округления: round, floor, ceil или truncate
+	DivisionRounding string `yaml:"division_rounding"`
+
+	// Время выполнения операций в миллисекундах
+	TimeAddition       int64 `yaml:"time_addition_ms"`
+	TimeSubtraction    int64 `yaml:"time_subtraction_ms"`
+	TimeMultiplication int64 `yaml:"time_multiplications_ms"`
+	TimeDivision       int64 `yaml:"time_divisions_ms"`
+}
+
+// defaultConfig – конфигурация по умолчанию
+func defaultConfig() *Config {
+	return &Config{
+		Addr:                  "8080",
+		DBPath:                defaultDBPath,
+		MaxBodyBytes:          defaultMaxBodyBytes,
+		MaxExpressionLength:   defaultMaxExpressionLength,
+		MaxNestingDepth:       calculation.DefaultMaxDepth,
+		MaxTasksPerExpression: defaultMaxTasksPerExpression,
+		IdempotencyTTL:        defaultIdempotencyTTL,
+		VisibilityTimeout:     defaultVisibilityTimeout,
+		JanitorInterval:       defaultJanitorInterval,
+		AgentInactiveAfter:    defaultAgentInactiveAfter,
+		StreamTimeout:         defaultStreamTimeout,
+		ComputingPower:        1,
+		TaskQueueSize:         defaultTaskQueueSize,
+		TaskQueueTimeout:      defaultTaskQueueTimeout * time.Millisecond,
+		ResultPrecision:       -1,
+		ResultFormat:          resultFormatAuto,
+		DivisionPrecision:     -1,
+		DivisionRounding:      roundingRound,
+		TimeAddition:          defaultOperationTime,
+		TimeSubtraction:       defaultOperationTime,
+		TimeMultiplication:    defaultOperationTime,
+		TimeDivision:          defaultOperationTime,
+	}
+}
+
+// ConfigFromEnv – загрузка конфигурации из переменных окружения
+func ConfigFromEnv() *Config {
+	config := defaultConfig()
+	config.applyEnv()
+	return config
+}
+
+// LoadConfig – конфигурация из YAML-файла path, поверх которой применяются
+// переменные окружения. Пустой path – только окружение
+func LoadConfig(path string) (*Config, error) {
+	config := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка при чтении конфигурации: %w", err)
+		}
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("ошибка в файле конфигурации %s: %w", path, err)
+		}
+	}
+	config.applyEnv()
+	return config, nil
+}
+
+// ConfigFromFlags – конфигурация по аргументам командной строки: файл из
+// --config, затем окружение, затем явно заданные флаги
+func ConfigFromFlags(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("orchestrator", flag.ContinueOnError)
+	path := flags.String("config", "", "path to YAML config file")
+	port := flags.String("port", "", "port to listen on")
+	dbPath := flags.String("db", "", "SQLite database path, empty for in-memory storage")
+	computingPower := flags.Int("computing-power", 0, "number of built-in agents")
+	queueSize := flags.Int("task-queue-size", 0, "task queue size")
+	deduplicate := flags.Bool("deduplicate", false, "return the ID of an equal existing expression instead of creating a new one")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config, err := LoadConfig(*path)
+	if err != nil {
+		return nil, err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			config.Addr = *port
+		case "db":
+			config.DBPath = *dbPath
+		case "computing-power":
+			config.ComputingPower = *computingPower
+		case "task-queue-size":
+			config.TaskQueueSize = *queueSize
+		case "deduplicate":
+			config.DeduplicateExpressions = *deduplicate
+		}
+	})
+	return config, nil
+}
+
+// applyEnv – замена значений заданными переменными окружения
+func (c *Config) applyEnv() {
+	if port := os.Getenv("PORT"); port != "" {
+		c.Addr = port
+	}
+	if port := os.Getenv("GRPC_PORT"); port != "" {
+		c.GRPCAddr = port
+	}
+	if cert := os.Getenv("TLS_CERT"); cert != "" {
+		c.TLSCert = cert
+	}
+	if key := os.Getenv("TLS_KEY"); key != "" {
+		c.TLSKey = key
+	}
+	if port := os.Getenv("HTTP_REDIRECT_PORT"); port != "" {
+		c.HTTPRedirectAddr = port
+	}
+	if path, ok := os.LookupEnv("DB_PATH"); ok {
+		c.DBPath = path
+	}
+	if key, ok := os.LookupEnv("API_KEY"); ok {
+		c.APIKey = key
+	}
+	if key, ok := os.LookupEnv("INTERNAL_KEY"); ok {
+		c.InternalKey = key
+	}
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		c.CORSAllowedOrigins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.CORSAllowedOrigins = append(c.CORSAllowedOrigins, origin)
+			}
+		}
+	}
+	c.MaxBodyBytes = int64FromEnv("MAX_BODY_BYTES", c.MaxBodyBytes)
+	c.MaxExpressionLength = int(int64FromEnv("MAX_EXPRESSION_LENGTH", int64(c.MaxExpressionLength)))
+	c.MaxNestingDepth = int(int64FromEnv("MAX_NESTING_DEPTH", int64(c.MaxNestingDepth)))
+	c.MaxOperand = float64FromEnv("MAX_OPERAND", c.MaxOperand)
+	c.MaxTasksPerExpression = int(int64FromEnv("MAX_TASKS_PER_EXPRESSION", int64(c.MaxTasksPerExpression)))
+	c.IdempotencyTTL = durationFromEnv("IDEMPOTENCY_TTL", c.IdempotencyTTL)
+	c.VisibilityTimeout = durationFromEnv("VISIBILITY_TIMEOUT", c.VisibilityTimeout)
+	c.ExpressionTimeout = durationFromEnv("EXPRESSION_TIMEOUT", c.ExpressionTimeout)
+	c.ExpressionTTL = durationFromEnv("EXPRESSION_TTL", c.ExpressionTTL)
+	c.JanitorInterval = durationFromEnv("JANITOR_INTERVAL", c.JanitorInterval)
+	c.AgentInactiveAfter = durationFromEnv("AGENT_INACTIVE_AFTER", c.AgentInactiveAfter)
+	c.StreamTimeout = durationFromEnv("STREAM_TIMEOUT", c.StreamTimeout)
+	c.DeduplicateExpressions = boolFromEnv("DEDUPLICATE_EXPRESSIONS", c.DeduplicateExpressions)
+	c.ResultCacheSize = int(int64FromEnv("RESULT_CACHE_SIZE", int64(c.ResultCacheSize)))
+	c.RateLimitRPS = int(int64FromEnv("RATE_LIMIT_RPS", int64(c.RateLimitRPS)))
+	c.MaxActiveExpressions = int(int64FromEnv("MAX_ACTIVE_EXPRESSIONS", int64(c.MaxActiveExpressions)))
+	c.ComputingPower = int(int64FromEnv("COMPUTING_POWER", int64(c.ComputingPower)))
+	c.TaskQueueSize = int(int64FromEnv("TASK_QUEUE_SIZE", int64(c.TaskQueueSize)))
+	c.TaskQueueTimeout = time.Duration(int64FromEnv("TASK_QUEUE_TIMEOUT_MS", c.TaskQueueTimeout.Milliseconds())) * time.Millisecond
+	c.ResultPrecision = int(int64FromEnv("RESULT_PRECISION", int64(c.ResultPrecision)))
+	if format, ok := os.LookupEnv("RESULT_FORMAT"); ok {
+		c.ResultFormat = format
+	}
+	c.DivisionPrecision = int(int64FromEnv("DIVISION_PRECISION", int64(c.DivisionPrecision)))
+	if mode, ok := os.LookupEnv("DIVISION_ROUNDING"); ok {
+		c.DivisionRounding = mode
+	}
+	c.TimeAddition = int64FromEnv("TIME_ADDITION_MS", c.TimeAddition)
+	c.TimeSubtraction = int64FromEnv("TIME_SUBTRACTION_MS", c.TimeSubtraction)
+	c.TimeMultiplication = int64FromEnv("TIME_MULTIPLICATIONS_MS", c.TimeMultiplication)
+	c.TimeDivision = int64FromEnv("TIME_DIVISIONS_MS", c.TimeDivision)
+}
+
+// Validate – проверка значений, которые иначе приведут к невнятной ошибке при запуске
+func (c *Config) Validate() error {
+	if err := validateAddr("PORT", c.Addr); err != nil {
+		return err
+	}
+	if c.GRPCAddr != "" {
+		if err := validateAddr("GRPC_PORT", c.GRPCAddr); err != nil {
+			return err
+		}
+	}
+	if !validRoundingMode(c.DivisionRounding) {
+		return fmt.Errorf("некорректный DIVISION_ROUNDING %q: ожидается round, floor, ceil или truncate", c.DivisionRounding)
+	}
+	if !validResultFormat(c.ResultFormat) {
+		return fmt.Errorf("некорректный RESULT_FORMAT %q: ожидается auto или fixed", c.ResultFormat)
+	}
+	// При нуле любое тело получило бы 413, любое выражение – ErrTooDeep, а
+	// очередь не приняла бы ни одной задачи
+	if c.MaxBodyBytes <= 0 {
+		return fmt.Errorf("некорректный MAX_BODY_BYTES %d: ожидается положительное число", c.MaxBodyBytes)
+	}
+	if c.MaxNestingDepth <= 0 {
+		return fmt.Errorf("некорректный MAX_NESTING_DEPTH %d: ожидается положительное число", c.MaxNestingDepth)
+	}
+	if c.TaskQueueSize <= 0 {
+		return fmt.Errorf("некорректный TASK_QUEUE_SIZE %d: ожидается положительное число", c.TaskQueueSize)
+	}
+	// Здесь 0 – допустимое значение: без ограничения и только внешние агенты
+	if c.MaxTasksPerExpression < 0 {
+		return fmt.Errorf("некорректный MAX_TASKS_PER_EXPRESSION %d: ожидается неотрицательное число", c.MaxTasksPerExpression)
+	}
+	if c.ComputingPower < 0 {
+		return fmt.Errorf("некорректный COMPUTING_POWER %d: ожидается неотрицательное число", c.ComputingPower)
+	}
+	// Оба интервала задают периоды тикеров и должны быть положительными
+	if c.VisibilityTimeout <= 0 {
+		return fmt.Errorf("некорректный VISIBILITY_TIMEOUT %s: ожидается положительная длительность", c.VisibilityTimeout)
+	}
+	if c.JanitorInterval <= 0 {
+		return fmt.Errorf("некорректный JANITOR_INTERVAL %s: ожидается положительная длительность", c.JanitorInterval)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("для HTTPS нужны оба параметра TLS_CERT и TLS_KEY")
+	}
+	if c.HTTPRedirectAddr != "" {
+		if !c.TLSEnabled() {
+			return fmt.Errorf("HTTP_REDIRECT_PORT имеет смысл только вместе с TLS_CERT и TLS_KEY")
+		}
+		return validateAddr("HTTP_REDIRECT_PORT", c.HTTPRedirectAddr)
+	}
+	return nil
+}
+
+// TLSEnabled – сервер слушает HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+// validateAddr – адрес должен быть портом 1..65535 или адресом "хост:порт"
+func validateAddr(name, addr string) error {
+	port := addr
+	if strings.Contains(addr, ":") {
+		var err error
+		if _, port, err = net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("некорректный адрес %s %q: %w", name, addr, err)
+		}
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("некорректный %s %q: ожидается число от 1 до 65535 или адрес вида 0.0.0.0:8080", name, addr)
+	}
+	return nil
+}
+
+// ListenAddr – адрес прослушивания: номер порта дополняется до ":порт"
+func (c *Config) ListenAddr() string {
+	return listenAddr(c.Addr)
+}
+
+func listenAddr(addr string) string {
+	if strings.Contains(addr, ":") {
+		return addr
+	}
+	return ":" + addr
+}
+
+// RoundResult – округление итога выражения до ResultPrecision знаков. Округляется
+// десятичная запись, поэтому 0.1+0.2 при точности 2 даёт ровно 0.3, а большие
+// числа не переполняются при масштабировании
+func (c *Config) RoundResult(x float64) float64 {
+	if c.ResultPrecision < 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+		return x
+	}
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(x, 'f', c.ResultPrecision, 64), 64)
+	if err != nil {
+		return x
+	}
+	return rounded
+}
+
+// OperationTime – время выполнения операции в миллисекундах. Степень
+// считается как умножение, остаток и целочисленное деление – как деление,
+// сравнение – как вычитание
+func (c *Config) OperationTime(operation string) int64 {
+	switch operation {
+	case "+":
+		return c.TimeAddition
+	case "-", "<", ">", "<=", ">=", "==", "!=":
+		return c.TimeSubtraction
+	case "*", "^":
+		return c.TimeMultiplication
+	case "/", "//", "%":
+		return c.TimeDivision
+	}
+	return 0
+}
+
+// boolFromEnv – чтение логического значения ("true", "1", "false", "0") из переменной окружения
+func boolFromEnv(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("некорректное значение переменной окружения", "name", name, "value", value, "default", def)
+		return def
+	}
+	return b
+}
+
+// int64FromEnv – чтение неотрицательного целого из переменной окружения
+func int64FromEnv(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		slog.Warn("некорректное значение переменной окружения", "name", name, "value", value, "default", def)
+		return def
+	}
+	return n
+}
+
+// float64FromEnv – чтение неотрицательного конечного числа из переменной окружения
+func float64FromEnv(name string, def float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	x, err := strconv.ParseFloat(value, 64)
+	if err != nil || x < 0 || math.IsInf(x, 0) || math.IsNaN(x) {
+		slog.Warn("некорректное значение переменной окружения", "name", name, "value", value, "default", def)
+		return def
+	}
+	return x
+}
+
+// durationFromEnv – чтение положительной длительности (например, "24h" или "90s")
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("некорректное значение переменной окружения", "name", name, "value", value, "default", def)
+		return def
+	}
+	return d
+}
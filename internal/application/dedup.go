@@ -0,0 +1,153 @@
+package application
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
+)
+
+// dedupIndex – ID выражений по их нормализованной записи для
+// DeduplicateExpressions. mu держится только на время поиска и резервирования
+// записи: само выражение принимается без блокировки, а одинаковый запрос,
+// пришедший в это время, ждёт, пока приём завершится
+type dedupIndex struct {
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+	// keys – нормализованная запись по ID выражения, чтобы убирать записи удалённых
+	keys map[string]string
+}
+
+// dedupEntry – выражение под нормализованной записью. ready закрывается,
+// когда приём выражения завершён, успешно или нет
+type dedupEntry struct {
+	id    string
+	ready chan struct{}
+}
+
+// newDedupIndex – индекс по выражениям, уже лежащим в хранилище
+func newDedupIndex(store Store) *dedupIndex {
+	d := &dedupIndex{entries: make(map[string]*dedupEntry), keys: make(map[string]string)}
+	for _, expr := range store.List() {
+		if !reusable(expr) {
+			continue
+		}
+		if normalized, err := calculation.NormalizeVars(expr.Expression, expr.Vars); err == nil {
+			entry := &dedupEntry{id: expr.ID, ready: make(chan struct{})}
+			close(entry.ready)
+			d.entries[normalized] = entry
+			d.keys[expr.ID] = normalized
+		}
+	}
+	return d
+}
+
+// reserve – запись для normalized: существующая и false или новая на id и true
+func (d *dedupIndex) reserve(normalized, id string) (*dedupEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if entry, found := d.entries[normalized]; found {
+		return entry, false
+	}
+	entry := &dedupEntry{id: id, ready: make(chan struct{})}
+	d.entries[normalized] = entry
+	d.keys[id] = normalized
+	return entry, true
+}
+
+// drop – удаление записи, если под normalized всё ещё она
+func (d *dedupIndex) drop(normalized string, entry *dedupEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.entries[normalized] == entry {
+		delete(d.entries, normalized)
+		delete(d.keys, entry.id)
+	}
+}
+
+// remove – удаление записи выражения id, например после его удаления из хранилища
+func (d *dedupIndex) remove(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	normalized, found := d.keys[id]
+	if !found {
+		return
+	}
+	delete(d.keys, id)
+	if entry := d.entries[normalized]; entry != nil && entry.id == id {
+		delete(d.entries, normalized)
+	}
+}
+
+// reusable – выражение можно отдать повторному запросу: оно считается или посчитано
+func reusable(expr Expression) bool {
+	return expr.Status == "pending" || expr.Status == "processing" || expr.Status == "completed"
+}
+
+// submitDeduplicated – приём выражения с учётом DeduplicateExpressions.
+// Возвращает ID, под которым выражение доступно клиенту: expressionID для
+// нового выражения или ID уже существующего равного. Выражения с callback_url
+// не объединяются – колбэк получил бы только первый клиент
+func (a *Application) submitDeduplicated(ctx context.Context, expressionID string, req Request) (string, error) {
+	if a.dedup == nil || req.CallbackURL != "" {
+		return expressionID, a.submitExpression(ctx, expressionID, req)
+	}
+	normalized, err := calculation.NormalizeVars(req.Expression, req.Vars)
+	if err != nil {
+		// Невалидное выражение отвергнет submitExpression с понятной ошибкой
+		return expressionID, a.submitExpression(ctx, expressionID, req)
+	}
+
+	for {
+		entry, reserved := a.dedup.reserve(normalized, expressionID)
+		if reserved {
+			err := a.submitExpression(ctx, expressionID, req)
+			if err != nil {
+				a.dedup.drop(normalized, entry)
+			}
+			close(entry.ready)
+			return expressionID, err
+		}
+
+		// Равное выражение принимается другим запросом – ждём его итога
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			return expressionID, ctx.Err()
+		}
+		if expr, found := a.store.Get(entry.id); found && reusable(expr) {
+			loggerFrom(ctx).Info("выражение уже принято", "expression_id", entry.id, "expression", req.Expression)
+			return entry.id, nil
+		}
+		// Выражение удалено или завершилось ошибкой – запись больше не нужна
+		a.dedup.drop(normalized, entry)
+	}
+}
+
+// forgetDeleted – уборка после удаления выражения из хранилища: его узлы
+// убираются из графа, а запись – из индекса дедупликации
+func (a *Application) forgetDeleted(id string) {
+	a.graph.forget(id)
+	if a.dedup != nil {
+		a.dedup.remove(id)
+	}
+}
+
+// rebindIdempotencyKey – перепривязка Idempotency-Key к ID существующего
+// выражения, если новое не создавалось из-за дедупликации
+func (a *Application) rebindIdempotencyKey(ctx context.Context, key string, req Request, id string) {
+	if err := a.store.ReleaseIdempotencyKey(key); err != nil {
+		loggerFrom(ctx).Error("ошибка при удалении ключа идемпотентности", "error", err)
+		return
+	}
+	_, _, err := a.store.ReserveIdempotencyKey(IdempotencyRecord{
+		Key:          key,
+		Fingerprint:  fingerprint(req),
+		ExpressionID: id,
+		ExpiresAt:    time.Now().Add(a.config.IdempotencyTTL),
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("ошибка при сохранении ключа идемпотентности", "error", err)
+	}
+}
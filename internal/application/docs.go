@@ -0,0 +1,42 @@
+package application
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec – описание /api/v1 и /api/v2 в формате OpenAPI 3. При изменении
+// маршрутов или тел запросов его нужно обновлять вместе с кодом
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// swaggerPage – Swagger UI с CDN, читающий /openapi.json
+const swaggerPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Calculator API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// OpenAPIHandler – отдача спецификации API
+func (a *Application) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// DocsHandler – страница Swagger UI
+func (a *Application) DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerPage))
+}
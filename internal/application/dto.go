@@ -0,0 +1,144 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Версии HTTP API. Маршруты /api/v1 и /api/v2 одинаковы, отличается запись
+// выражения в ответах: у каждой версии своя структура, поэтому новые поля
+// Expression попадают только в /api/v2, а клиенты /api/v1 их не видят
+const (
+	apiV1 = 1
+	apiV2 = 2
+)
+
+// apiVersionKey – ключ контекста с версией API запроса
+type apiVersionKey struct{}
+
+// withAPIVersion – middleware подмаршрутизатора, отмечающий запросы его версией API
+func withAPIVersion(version int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+		})
+	}
+}
+
+// apiVersionFrom – версия API запроса; без отметки – apiV1
+func apiVersionFrom(ctx context.Context) int {
+	if version, ok := ctx.Value(apiVersionKey{}).(int); ok {
+		return version
+	}
+	return apiV1
+}
+
+// expressionDTO – выражение в ответе одной из версий API; у каждой версии есть
+// и запись простым текстом
+type expressionDTO interface {
+	plainTexter
+}
+
+// view – выражение для ответа в формате версии API из ctx с итогом в формате format
+func (a *Application) view(ctx context.Context, expr Expression, format string) expressionDTO {
+	if apiVersionFrom(ctx) == apiV2 {
+		return a.viewV2(expr, format)
+	}
+	return a.viewV1(expr, format)
+}
+
+// expressionV1 – выражение в ответе /api/v1 в исходном виде: только id,
+// expression, status и result, нулевой итог не выводится. Остальные поля
+// Expression есть лишь в /api/v2
+type expressionV1 struct {
+	ID         string           `json:"id"`
+	Expression string           `json:"expression"`
+	Status     string           `json:"status"`
+	Result     *formattedNumber `json:"result,omitempty"`
+
+	source Expression
+	result formattedNumber
+}
+
+// viewV1 – выражение в формате /api/v1
+func (a *Application) viewV1(expr Expression, format string) expressionV1 {
+	result := a.formatResult(expr.Result, format)
+	v := expressionV1{
+		ID:         expr.ID,
+		Expression: expr.Expression,
+		Status:     expr.Status,
+		source:     expr,
+		result:     result,
+	}
+	if expr.Result != 0 {
+		v.Result = &result
+	}
+	return v
+}
+
+// PlainText – текстовая запись выражения с итогом в выбранном формате
+func (v expressionV1) PlainText() string {
+	return v.source.plainText(v.result.String())
+}
+
+// expressionV2 – выражение в ответе /api/v2. Итог посчитанного выражения
+// выводится всегда, в том числе нулевой, у остальных – null; счётчики задач
+// собраны в объект progress
+type expressionV2 struct {
+	ID          string             `json:"id"`
+	Expression  string             `json:"expression"`
+	Vars        map[string]float64 `json:"vars,omitempty"`
+	Status      string             `json:"status"`
+	Result      *formattedNumber   `json:"result"`
+	Error       string             `json:"error,omitempty"`
+	Progress    progressV2         `json:"progress"`
+	Priority    int                `json:"priority"`
+	CallbackURL string             `json:"callback_url,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+
+	source Expression
+	result formattedNumber
+}
+
+// progressV2 – прогресс выражения в ответе /api/v2
+type progressV2 struct {
+	CompletedTasks int     `json:"completed_tasks"`
+	TotalTasks     int     `json:"total_tasks"`
+	Percent        float64 `json:"percent"`
+}
+
+// viewV2 – выражение в формате /api/v2; так же оно отправляется на callback_url
+func (a *Application) viewV2(expr Expression, format string) expressionV2 {
+	result := a.formatResult(expr.Result, format)
+	v := expressionV2{
+		ID:         expr.ID,
+		Expression: expr.Expression,
+		Vars:       expr.Vars,
+		Status:     expr.Status,
+		Error:      expr.Error,
+		Progress: progressV2{
+			CompletedTasks: expr.CompletedTasks,
+			TotalTasks:     expr.TotalTasks,
+			Percent:        expr.Progress,
+		},
+		Priority:    expr.Priority,
+		CallbackURL: expr.CallbackURL,
+		CreatedAt:   expr.CreatedAt,
+		UpdatedAt:   expr.UpdatedAt,
+		source:      expr,
+		result:      result,
+	}
+	if expr.Status == "completed" {
+		v.Result = &result
+	}
+	return v
+}
+
+// PlainText – текстовая запись выражения, та же, что и в /api/v1
+func (v expressionV2) PlainText() string {
+	return v.source.plainText(v.result.String())
+}
@@ -0,0 +1,39 @@
+package application
+
+import (
+	"context"
+	"time"
+)
+
+// StartAgent – запуск встроенного агента для тестов
+func (a *Application) StartAgent(ctx context.Context) {
+	a.startAgent(ctx)
+}
+
+// WatchLeases – запуск возврата просроченных задач в очередь для тестов
+func (a *Application) WatchLeases(ctx context.Context) {
+	a.watchLeases(ctx)
+}
+
+// RunJanitor – запуск фоновой уборки хранилища для тестов
+func (a *Application) RunJanitor(ctx context.Context) {
+	a.runJanitor(ctx)
+}
+
+// HTTPSRedirect – обработчик перенаправления на HTTPS для тестов
+var HTTPSRedirect = httpsRedirect
+
+// PutExpression – сохранение выражения в обход очереди задач
+func (a *Application) PutExpression(expr *Expression) error {
+	return a.store.Add(expr)
+}
+
+// TaskQueueCap – ёмкость очереди задач
+func (a *Application) TaskQueueCap() int {
+	return a.tasks.Cap()
+}
+
+// SetCallbackRetryDelay – короткая пауза между попытками доставки колбэка в тестах
+func SetCallbackRetryDelay(d time.Duration) {
+	callbackRetryDelay = d
+}
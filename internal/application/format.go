@@ -0,0 +1,69 @@
+package application
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Форматы записи итога выражения в ответах: "auto" – как encoding/json
+// (очень большие и очень малые числа в научной записи, например 1e-7),
+// "fixed" – всегда десятичная запись с ResultPrecision знаками после запятой
+// (при отрицательной точности – со всеми значащими цифрами)
+const (
+	resultFormatAuto  = "auto"
+	resultFormatFixed = "fixed"
+)
+
+// validResultFormat – формат записи итога поддерживается
+func validResultFormat(format string) bool {
+	return format == resultFormatAuto || format == resultFormatFixed
+}
+
+// formattedNumber – итог выражения, который сериализуется в выбранном формате
+type formattedNumber struct {
+	value float64
+	// fixed – десятичная запись с precision знаками вместо записи encoding/json
+	fixed     bool
+	precision int
+}
+
+// MarshalJSON – число в формате fixed или auto. Запись остаётся JSON-числом,
+// поэтому клиенты разбирают её как раньше
+func (n formattedNumber) MarshalJSON() ([]byte, error) {
+	if !n.fixed {
+		return json.Marshal(n.value)
+	}
+	if _, err := json.Marshal(n.value); err != nil {
+		// NaN и бесконечность в JSON не записываются ни в каком формате
+		return nil, err
+	}
+	return strconv.AppendFloat(nil, n.value, 'f', n.precision, 64), nil
+}
+
+// String – та же запись, что и в JSON
+func (n formattedNumber) String() string {
+	if !n.fixed {
+		return formatNumber(n.value)
+	}
+	return strconv.FormatFloat(n.value, 'f', n.precision, 64)
+}
+
+// resultFormat – формат итога для ответа: параметр format запроса или
+// ResultFormat из конфигурации. При неизвестном формате отвечает 400
+func (a *Application) resultFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return a.config.ResultFormat, true
+	}
+	if !validResultFormat(format) {
+		writeError(w, http.StatusBadRequest, "invalid format, expected auto or fixed")
+		return "", false
+	}
+	return format, true
+}
+
+// formatResult – итог x в формате format
+func (a *Application) formatResult(x float64, format string) formattedNumber {
+	return formattedNumber{value: x, fixed: format == resultFormatFixed, precision: a.config.ResultPrecision}
+}
@@ -0,0 +1,349 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// graphNode – промежуточный узел графа задач выражения. Бинарные операции
+// выдаются агентам как задачи, а унарный минус и функции (local) считает сам
+// оркестратор, как только готов их аргумент. Зависимости задачи описаны
+// ссылками Arg1Ref/Arg2Ref, parent – ID задачи, которая ссылается на эту.
+// leasedUntil – до какого момента задача занята внешним агентом, taken –
+// задача выдана агенту. done и result – задача посчитана, err – с ошибкой
+type graphNode struct {
+	task         Task
+	expressionID string
+	local        bool
+	parent       string
+	leasedUntil  time.Time
+	taken        bool
+	done         bool
+	result       float64
+	err          string
+}
+
+// resolved – все ссылки на аргументы заменены числами
+func (n *graphNode) resolved() bool {
+	return n.task.Arg1Ref == "" && n.task.Arg2Ref == ""
+}
+
+// taskGraph – промежуточные узлы всех вычисляемых выражений. byExpression
+// хранит узлы выражения и после его завершения, чтобы было видно, как
+// считались задачи; выражение забывается вместе с удалением из хранилища
+type taskGraph struct {
+	mu           sync.Mutex
+	nodes        map[string]*graphNode   // по ID задачи, только ещё не посчитанные
+	byExpression map[string][]*graphNode // все узлы каждого выражения
+}
+
+// step – итог обработки результата задачи
+type step struct {
+	expressionID string
+	// task – задача, результат которой обработан
+	task Task
+	// ready – задачи, у которых после этого шага посчитаны все аргументы
+	ready []Task
+	// done – выражение посчитано целиком, result – его значение
+	done   bool
+	result float64
+	// err – ошибка при вычислении унарной операции оркестратором
+	err error
+}
+
+func newTaskGraph() *taskGraph {
+	return &taskGraph{
+		nodes:        make(map[string]*graphNode),
+		byExpression: make(map[string][]*graphNode),
+	}
+}
+
+// graphBuilder – разворачивает дерево выражения в узлы графа
+type graphBuilder struct {
+	expressionID string
+	// priority – приоритет выражения, его наследуют все задачи
+	priority int
+	// trace – контекст трассировки приёма выражения, его несут все задачи
+	trace  propagation.MapCarrier
+	config *Config
+	nodes  []*graphNode
+	// tasks – сколько задач для агентов уже получилось
+	tasks int
+}
+
+// tooManyTasksError – выражение раскладывается больше чем на limit задач
+type tooManyTasksError struct {
+	limit int
+}
+
+func (e *tooManyTasksError) Error() string {
+	return fmt.Sprintf("expression needs more than %d tasks", e.limit)
+}
+
+// build – строит граф задач для дерева выражения и возвращает задачи, готовые
+// к выдаче, и общее число задач для агентов. Поддеревья без бинарных операций
+// сворачиваются в число сразу; если так свернулось всё выражение, задач нет,
+// а constant содержит его значение. Задачи запоминают контекст трассировки ctx
+func (g *taskGraph) build(ctx context.Context, expressionID string, tree *calculation.Node, priority int, config *Config) (ready []Task, total int, constant float64, err error) {
+	b := &graphBuilder{expressionID: expressionID, priority: priority, trace: traceCarrier(ctx), config: config}
+	value, root, err := b.compile(tree)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if root == nil {
+		return nil, 0, value, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, node := range b.nodes {
+		g.nodes[node.task.ID] = node
+		if node.local {
+			continue
+		}
+		total++
+		if node.resolved() {
+			ready = append(ready, node.task)
+		}
+	}
+	g.byExpression[expressionID] = b.nodes
+	return ready, total, 0, nil
+}
+
+// check – проверка, что дерево раскладывается на задачи, без добавления узлов в граф
+func (g *taskGraph) check(tree *calculation.Node, config *Config) error {
+	_, _, err := (&graphBuilder{config: config}).compile(tree)
+	return err
+}
+
+// compile – возвращает либо значение поддерева, либо узел, который его посчитает
+func (b *graphBuilder) compile(n *calculation.Node) (float64, *graphNode, error) {
+	switch n.Kind {
+	case calculation.NumberNode:
+		return n.Value, nil, nil
+	case calculation.UnaryNode, calculation.FuncNode:
+		value, child, err := b.compile(n.Left)
+		if err != nil {
+			return 0, nil, err
+		}
+		op := n.Op
+		if n.Kind == calculation.UnaryNode {
+			if op == "+" {
+				return value, child, nil
+			}
+			op = "neg"
+		}
+		if child == nil {
+			value, err := applyLocal(op, value)
+			return value, nil, err
+		}
+		node := b.add(Task{Operation: op, Arg1Ref: child.task.ID}, true)
+		child.parent = node.task.ID
+		return 0, node, nil
+	}
+
+	arg1, left, err := b.compile(n.Left)
+	if err != nil {
+		return 0, nil, err
+	}
+	arg2, right, err := b.compile(n.Right)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n.Op == "//" && (left == nil && arg1 != math.Trunc(arg1) || right == nil && arg2 != math.Trunc(arg2)) {
+		// Дробный операнд целочисленного деления виден ещё до вычисления
+		return 0, nil, calculation.ErrNonIntegerOperand
+	}
+	// Задачи считаются по ходу раскладки, поэтому огромное выражение
+	// отвергается, не успев создать тысячи узлов
+	b.tasks++
+	if limit := b.config.MaxTasksPerExpression; limit > 0 && b.tasks > limit {
+		return 0, nil, &tooManyTasksError{limit: limit}
+	}
+	node := b.add(Task{
+		Arg1:          arg1,
+		Arg2:          arg2,
+		Operation:     n.Op,
+		OperationTime: b.config.OperationTime(n.Op),
+	}, false)
+	if left != nil {
+		node.task.Arg1Ref = left.task.ID
+		left.parent = node.task.ID
+	}
+	if right != nil {
+		node.task.Arg2Ref = right.task.ID
+		right.parent = node.task.ID
+	}
+	return 0, node, nil
+}
+
+func (b *graphBuilder) add(task Task, local bool) *graphNode {
+	task.ID = generateUniqueID()
+	task.Priority = b.priority
+	task.trace = b.trace
+	node := &graphNode{task: task, expressionID: b.expressionID, local: local}
+	b.nodes = append(b.nodes, node)
+	return node
+}
+
+// applyLocal – унарная операция, которую оркестратор считает сам
+func applyLocal(op string, x float64) (float64, error) {
+	if op == "neg" {
+		return -x, nil
+	}
+	return calculation.ApplyFunc(op, x)
+}
+
+// operation – операция ещё не посчитанной задачи
+func (g *taskGraph) operation(taskID string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	node, found := g.nodes[taskID]
+	if !found {
+		return "", false
+	}
+	return node.task.Operation, true
+}
+
+// complete – подставляет результат задачи в аргумент родителя, который на неё
+// ссылается. false, если задача неизвестна (выражение уже завершилось ошибкой
+// или удалено)
+func (g *taskGraph) complete(taskID string, result float64) (step, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	node, found := g.nodes[taskID]
+	if !found {
+		return step{}, false
+	}
+	st := step{expressionID: node.expressionID, task: node.task}
+	delete(g.nodes, taskID)
+	node.done, node.result = true, result
+
+	value := result
+	for {
+		parent, found := g.nodes[node.parent]
+		if !found {
+			st.done = true
+			st.result = value
+			g.removeLocked(st.expressionID)
+			return st, true
+		}
+
+		if parent.task.Arg1Ref == node.task.ID {
+			parent.task.Arg1 = value
+			parent.task.Arg1Ref = ""
+		}
+		if parent.task.Arg2Ref == node.task.ID {
+			parent.task.Arg2 = value
+			parent.task.Arg2Ref = ""
+		}
+		if !parent.resolved() {
+			return st, true
+		}
+		if !parent.local {
+			st.ready = append(st.ready, parent.task)
+			return st, true
+		}
+
+		// Унарную операцию считаем сразу и поднимаемся выше
+		delete(g.nodes, parent.task.ID)
+		v, err := applyLocal(parent.task.Operation, parent.task.Arg1)
+		if err != nil {
+			parent.err = err.Error()
+			st.err = err
+			g.removeLocked(st.expressionID)
+			return st, true
+		}
+		parent.done, parent.result = true, v
+		value = v
+		node = parent
+	}
+}
+
+// take – отметка, что задача выдана агенту. Возвращает ID её выражения;
+// false, если выражение уже не считается
+func (g *taskGraph) take(taskID string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	node, found := g.nodes[taskID]
+	if !found {
+		return "", false
+	}
+	node.taken = true
+	return node.expressionID, true
+}
+
+// fail – задача посчитана с ошибкой reason: её выражение больше не считается.
+// Возвращает ID выражения; false, если задача неизвестна
+func (g *taskGraph) fail(taskID, reason string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	node, found := g.nodes[taskID]
+	if !found {
+		return "", false
+	}
+	node.err = reason
+	g.removeLocked(node.expressionID)
+	return node.expressionID, true
+}
+
+// tasks – состояние всех задач выражения в порядке раскладки: сначала
+// вложенные операции, последней – корень. Пусто, если граф выражения не знает
+// (в нём нет бинарных операций, или оно посчитано до перезапуска)
+func (g *taskGraph) tasks(expressionID string) []TaskState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	nodes := g.byExpression[expressionID]
+	states := make([]TaskState, 0, len(nodes))
+	for _, node := range nodes {
+		state := TaskState{Task: node.task, Local: node.local, Error: node.err}
+		switch {
+		case node.done:
+			state.Status = "completed"
+			result := node.result
+			state.Result = &result
+		case node.err != "":
+			state.Status = "error"
+		case g.nodes[node.task.ID] != node:
+			// Выражение завершилось раньше, чем дошло до задачи
+			state.Status = "cancelled"
+		case !node.resolved():
+			state.Status = "waiting"
+		case node.taken:
+			state.Status = "processing"
+		default:
+			state.Status = "pending"
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// remove – выражение больше не считается (например, после ошибки в одной из
+// задач): его задачи не выдаются, а результаты отклоняются
+func (g *taskGraph) remove(expressionID string) {
+	g.mu.Lock()
+	g.removeLocked(expressionID)
+	g.mu.Unlock()
+}
+
+func (g *taskGraph) removeLocked(expressionID string) {
+	for _, node := range g.byExpression[expressionID] {
+		delete(g.nodes, node.task.ID)
+	}
+}
+
+// forget – удаление выражения из графа вместе с историей его задач
+func (g *taskGraph) forget(expressionID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeLocked(expressionID)
+	delete(g.byExpression, expressionID)
+}
@@ -0,0 +1,130 @@
+package application
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/taskpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcTaskService – выдача задач внешним агентам через двунаправленный стрим
+// taskpb.TaskService. Задачи, выданные по стриму, занимаются так же, как через
+// GET /internal/task, поэтому при обрыве стрима возвращаются в очередь по
+// VisibilityTimeout
+type grpcTaskService struct {
+	taskpb.UnimplementedTaskServiceServer
+	app *Application
+}
+
+// GRPCServer – gRPC-сервер внутреннего API; защищён тем же InternalKey,
+// что и /internal/*
+func (a *Application) GRPCServer() *grpc.Server {
+	srv := grpc.NewServer()
+	taskpb.RegisterTaskServiceServer(srv, &grpcTaskService{app: a})
+	return srv
+}
+
+// Process – агент присылает результаты, оркестратор отправляет задачи,
+// пока у агента меньше computing-power невыполненных задач. Метаданные
+// operations (например, "+,-") ограничивают задачи операциями агента
+func (s *grpcTaskService) Process(stream grpc.BidiStreamingServer[taskpb.Result, taskpb.Task]) error {
+	ctx := stream.Context()
+	md, _ := metadata.FromIncomingContext(ctx)
+	if !s.authorized(md) {
+		return status.Error(codes.Unauthenticated, "missing or invalid internal key")
+	}
+	capacity := 1
+	if values := md.Get("computing-power"); len(values) > 0 {
+		if n, err := strconv.Atoi(values[0]); err == nil && n > 0 {
+			capacity = n
+		}
+	}
+	ops := parseOperations(strings.Join(md.Get("operations"), ","))
+
+	// slots – свободные места у агента: результат освобождает место
+	slots := make(chan struct{}, capacity)
+	for i := 0; i < capacity; i++ {
+		slots <- struct{}{}
+	}
+	var received sync.WaitGroup
+	received.Add(1)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer received.Done()
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			result := Result{ID: res.GetId(), Result: res.GetResult(), Error: res.GetError()}
+			if s.app.completeTask(result) {
+				s.app.metrics.observeResult("external", result)
+			}
+			select {
+			case slots <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	defer received.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-recvErr:
+			// Агент закрыл стрим или соединение оборвалось
+			return err
+		case <-slots:
+		}
+
+		var task Task
+		for {
+			var found bool
+			task, found = s.app.waitForTask(ctx, time.Second, ops...)
+			if found {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case err := <-recvErr:
+				return err
+			default:
+			}
+		}
+		s.app.graph.lease(task.ID, time.Now().Add(s.app.config.VisibilityTimeout))
+		err := stream.Send(&taskpb.Task{
+			Id:            task.ID,
+			Arg1:          task.Arg1,
+			Arg2:          task.Arg2,
+			Operation:     task.Operation,
+			OperationTime: task.OperationTime,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// authorized – проверка "authorization: Bearer <InternalKey>" в метаданных
+func (s *grpcTaskService) authorized(md metadata.MD) bool {
+	key := s.app.config.InternalKey
+	if key == "" {
+		return true
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return false
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1
+}
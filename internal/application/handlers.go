@@ -0,0 +1,630 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// writeJSON – отправка JSON-ответа с заданным кодом статуса
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("ошибка при кодировании ответа", "error", err)
+	}
+}
+
+// writeError – отправка ошибки в виде JSON {"error": "..."}
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// unmatchedHandler – ответ на запрос, для которого router не нашёл маршрута,
+// в JSON, как и остальные ошибки API: 405 с заголовком Allow, если путь
+// известен, но метод не тот, иначе 404
+func unmatchedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := allowedMethods(router, r)
+		if len(allowed) == 0 {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+}
+
+// allowedMethods – методы маршрутов router, путь которых совпадает с путём r.
+// Маршруты перебираются по одному: подмаршрутизаторы gorilla/mux теряют
+// признак несовпадения метода, и router.Match отвечает «не найдено»
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Маршрут без ограничения методов, например префикс подмаршрутизатора
+			return nil
+		}
+		for _, method := range methods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if route.Match(probe, &match) && !slices.Contains(allowed, method) {
+				allowed = append(allowed, method)
+			}
+		}
+		return nil
+	})
+	return allowed
+}
+
+// readJSON – разбор JSON-тела запроса размером не больше MaxBodyBytes.
+// Неизвестные поля – ошибка, чтобы опечатка в ключе не терялась молча. Если
+// тело не удалось прочитать, ответ уже отправлен: 413 для слишком большого
+// тела, иначе 400 с сообщением invalid и уточнением причины
+func (a *Application) readJSON(w http.ResponseWriter, r *http.Request, v interface{}, invalid string) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, a.config.MaxBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return false
+	}
+	writeError(w, http.StatusBadRequest, describeJSONError(err, invalid))
+	return false
+}
+
+// describeJSONError – сообщение об ошибке разбора тела: пустое тело,
+// синтаксическая ошибка с позицией, поле не того типа или неизвестное поле
+func describeJSONError(err error, invalid string) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return "empty body"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return invalid + ": unexpected end of JSON"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("%s: %s at position %d", invalid, strings.TrimPrefix(syntaxErr.Error(), "json: "), syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("%s: field %q must be %s", invalid, typeErr.Field, typeErr.Type)
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		return invalid + ": " + strings.TrimPrefix(err.Error(), "json: ")
+	}
+	return invalid
+}
+
+// AddExpressionHandler – обработчик POST-запроса для добавления нового выражения
+func (a *Application) AddExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if !a.readJSON(w, r, &req, "invalid expression payload") {
+		return
+	}
+
+	// Генерация уникального ID для выражения
+	expressionID := generateUniqueID()
+
+	// Повтор запроса с тем же Idempotency-Key получает прежний ID
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key != "" {
+		rec, reserved, err := a.store.ReserveIdempotencyKey(IdempotencyRecord{
+			Key:          key,
+			Fingerprint:  fingerprint(req),
+			ExpressionID: expressionID,
+			ExpiresAt:    time.Now().Add(a.config.IdempotencyTTL),
+		})
+		if err != nil {
+			loggerFrom(r.Context()).Error("ошибка при сохранении ключа идемпотентности", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to store idempotency key")
+			return
+		}
+		if !reserved {
+			if rec.Fingerprint != fingerprint(req) {
+				writeError(w, http.StatusUnprocessableEntity, "idempotency key was used with a different expression")
+				return
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeJSON(w, http.StatusCreated, map[string]string{"id": rec.ExpressionID})
+			return
+		}
+	}
+
+	id, err := a.submitDeduplicated(a.withClient(r), expressionID, req)
+	if err != nil {
+		if key != "" {
+			// Выражение не принято – повтор с тем же ключом должен попробовать снова
+			if err := a.store.ReleaseIdempotencyKey(key); err != nil {
+				loggerFrom(r.Context()).Error("ошибка при удалении ключа идемпотентности", "error", err)
+			}
+		}
+		writeSubmitError(w, err)
+		return
+	}
+	if key != "" && id != expressionID {
+		// Выражение оказалось повтором – ключ должен вести к существующему ID
+		a.rebindIdempotencyKey(r.Context(), key, req, id)
+	}
+
+	// Возвращаем ответ с ID выражения
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+}
+
+// ValidateExpressionHandler – проверка выражения теми же правилами, что
+// и при приёме, но без создания выражения и постановки задач
+func (a *Application) ValidateExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if !a.readJSON(w, r, &req, "invalid expression payload") {
+		return
+	}
+
+	tree, rejected := a.checkRequest(req)
+	if rejected == nil {
+		if err := a.graph.check(tree, a.config); err != nil {
+			rejected = buildError(err)
+		}
+	}
+	if rejected != nil {
+		body := map[string]interface{}{"valid": false, "error": rejected.message}
+		if rejected.parse != nil {
+			body["position"] = rejected.parse.Pos
+			body["token"] = rejected.parse.Token
+		}
+		writeJSON(w, rejected.status, body)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"valid": true})
+}
+
+// BatchRequest – пакет выражений для POST /api/v1/calculate/batch
+type BatchRequest struct {
+	Expressions []string `json:"expressions"`
+}
+
+// BatchItem – итог приёма одного выражения пакета: ID или ошибка
+type BatchItem struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// AddBatchHandler – приём пакета выражений. Каждое выражение проверяется и
+// ставится в очередь отдельно, поэтому невалидные элементы не мешают остальным
+func (a *Application) AddBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if !a.readJSON(w, r, &req, "invalid batch payload") {
+		return
+	}
+	if len(req.Expressions) == 0 {
+		writeError(w, http.StatusUnprocessableEntity, "batch has no expressions")
+		return
+	}
+
+	results := make([]BatchItem, 0, len(req.Expressions))
+	for i, expression := range req.Expressions {
+		item := BatchItem{Index: i}
+		id, err := a.submitDeduplicated(a.withClient(r), generateUniqueID(), Request{Expression: expression})
+		var rejected *submitError
+		switch {
+		case err == nil:
+			item.ID = id
+		case errors.As(err, &rejected):
+			item.Error = rejected.message
+		default:
+			// Клиент ушёл – отвечать некому
+			return
+		}
+		results = append(results, item)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// GetExpressionsHandler – обработчик GET-запроса списка выражений. Список
+// отдаётся страницами: limit (по умолчанию 50) и offset, total – сколько
+// выражений всего. Выражения упорядочены по времени создания, при равном
+// времени – по ID, поэтому страницы не пересекаются; sort=desc – сначала новые.
+// Параметр status оставляет только выражения с этим статусом, format задаёт
+// запись итогов (auto или fixed)
+func (a *Application) GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	order := r.URL.Query().Get("sort")
+	if order != "" && order != "asc" && order != "desc" {
+		writeError(w, http.StatusBadRequest, "invalid sort, expected asc or desc")
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && !slices.Contains(expressionStatuses, status) {
+		writeError(w, http.StatusBadRequest, "invalid status, expected one of: "+strings.Join(expressionStatuses, ", "))
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultPageLimit)
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	format, ok := a.resultFormat(w, r)
+	if !ok {
+		return
+	}
+
+	list := a.store.List()
+	if status != "" {
+		filtered := list[:0]
+		for _, expr := range list {
+			if expr.Status == status {
+				filtered = append(filtered, expr)
+			}
+		}
+		list = filtered
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if order == "desc" {
+			i, j = j, i
+		}
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	total := len(list)
+	page := make([]expressionDTO, 0, min(limit, max(total-offset, 0)))
+	for _, expr := range list[min(offset, total):min(offset+limit, total)] {
+		page = append(page, a.view(r.Context(), expr, format))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"expressions": page,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+// defaultPageLimit – размер страницы списка выражений по умолчанию
+const defaultPageLimit = 50
+
+// queryInt – целый query-параметр запроса или def, если параметра нет
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// GetExpressionByIDHandler – обработчик GET-запроса выражения по ID; параметр
+// format задаёт запись итога
+func (a *Application) GetExpressionByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	format, ok := a.resultFormat(w, r)
+	if !ok {
+		return
+	}
+
+	expr, found := a.store.Get(id)
+	if !found {
+		writeError(w, http.StatusNotFound, "expression not found")
+		return
+	}
+
+	// С Accept: text/plain выражение отдаётся строкой вида "2 + 2 = 4 (completed)"
+	respond(w, r, http.StatusOK, a.view(r.Context(), expr, format))
+}
+
+// ResultResponse – ответ GET /api/v1/expressions/{id}/result
+type ResultResponse struct {
+	Result float64 `json:"result"`
+}
+
+// resultView – ResultResponse с итогом в выбранном формате
+type resultView struct {
+	Result formattedNumber `json:"result"`
+}
+
+// GetExpressionResultHandler – только результат посчитанного выражения.
+// Пока выражение считается или если оно завершилось без результата, отвечаем 409
+func (a *Application) GetExpressionResultHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	format, ok := a.resultFormat(w, r)
+	if !ok {
+		return
+	}
+
+	expr, found := a.store.Get(id)
+	if !found {
+		writeError(w, http.StatusNotFound, "expression not found")
+		return
+	}
+	switch expr.Status {
+	case "completed":
+		writeJSON(w, http.StatusOK, resultView{Result: a.formatResult(expr.Result, format)})
+	case "pending", "processing":
+		writeError(w, http.StatusConflict, "expression is still being calculated")
+	default:
+		writeError(w, http.StatusConflict, fmt.Sprintf("expression has no result: status %s", expr.Status))
+	}
+}
+
+// GetExpressionTasksHandler – задачи, на которые разложено выражение, с их
+// статусами и промежуточными результатами. У выражения без бинарных операций
+// задач нет; задачи выражений, посчитанных до перезапуска, не сохраняются
+func (a *Application) GetExpressionTasksHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if _, found := a.store.Get(id); !found {
+		writeError(w, http.StatusNotFound, "expression not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tasks": a.graph.tasks(id)})
+}
+
+// DeleteExpressionHandler – удаление выражения по ID. Удалить можно только
+// выражение с итогом ("completed" или "error"); пока его задачи считаются,
+// отвечаем 409, чтобы агент не прислал результат для пропавшей записи
+func (a *Application) DeleteExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	expr, found := a.store.Get(id)
+	if !found {
+		writeError(w, http.StatusNotFound, "expression not found")
+		return
+	}
+	if expr.Status == "pending" || expr.Status == "processing" {
+		writeError(w, http.StatusConflict, "expression is still being calculated")
+		return
+	}
+
+	found, err := a.store.Delete(id)
+	if err != nil {
+		loggerFrom(r.Context()).Error("ошибка при удалении выражения", "expression_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete expression")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "expression not found")
+		return
+	}
+	a.forgetDeleted(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteExpressionsHandler – удаление всех выражений в статусе из параметра
+// status, например ?status=completed; в ответе число удалённых. Без status
+// и для статусов "pending" и "processing" отвечаем 409, чтобы случайно не
+// стереть выражения, задачи которых ещё считаются
+func (a *Application) DeleteExpressionsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		writeError(w, http.StatusConflict, "status is required to delete expressions")
+		return
+	}
+	if !slices.Contains(expressionStatuses, status) {
+		writeError(w, http.StatusBadRequest, "invalid status, expected one of: "+strings.Join(expressionStatuses, ", "))
+		return
+	}
+	if status == "pending" || status == "processing" {
+		writeError(w, http.StatusConflict, "expressions are still being calculated")
+		return
+	}
+
+	deleted, err := a.store.DeleteByStatus(status)
+	if err != nil {
+		loggerFrom(r.Context()).Error("ошибка при удалении выражений", "status", status, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete expressions")
+		return
+	}
+	for _, id := range deleted {
+		a.forgetDeleted(id)
+	}
+	loggerFrom(r.Context()).Info("выражения удалены", "status", status, "count", len(deleted))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": len(deleted)})
+}
+
+// CancelExpressionHandler – отмена выражения в статусе "pending" или "processing".
+// Узлы выражения убираются из графа, поэтому его задачи из очереди агентам уже
+// не выдаются, а присланные результаты отклоняются. Выражение с итогом отменить
+// нельзя – 409
+func (a *Application) CancelExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var cancelled bool
+	found, err := a.store.Update(id, func(expr *Expression) {
+		if expr.Status == "pending" || expr.Status == "processing" {
+			expr.Status = "cancelled"
+			cancelled = true
+		}
+	})
+	if err != nil {
+		loggerFrom(r.Context()).Error("ошибка при отмене выражения", "expression_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to cancel expression")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "expression not found")
+		return
+	}
+	if !cancelled {
+		writeError(w, http.StatusConflict, "expression is already finished")
+		return
+	}
+	a.graph.remove(id)
+	a.releaseActive(id)
+
+	expr, _ := a.store.Get(id)
+	writeJSON(w, http.StatusOK, a.view(r.Context(), expr, a.config.ResultFormat))
+}
+
+// RetryExpressionHandler – повторный запуск выражения в статусе "error" или
+// "cancelled": оно раскладывается на задачи заново и возвращается в "pending".
+// Выражение, которое считается или уже посчитано, перезапустить нельзя – 409
+func (a *Application) RetryExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var retried bool
+	var expression string
+	var vars map[string]float64
+	var priority int
+	found, err := a.store.Update(id, func(expr *Expression) {
+		if expr.Status == "error" || expr.Status == "cancelled" {
+			// Занимаем выражение сразу, чтобы параллельный retry получил 409
+			expr.Status = "pending"
+			expr.Error = ""
+			expression = expr.Expression
+			vars = expr.Vars
+			priority = expr.Priority
+			retried = true
+		}
+	})
+	if err != nil {
+		loggerFrom(r.Context()).Error("ошибка при перезапуске выражения", "expression_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to retry expression")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "expression not found")
+		return
+	}
+	if !retried {
+		writeError(w, http.StatusConflict, "only failed or cancelled expressions can be retried")
+		return
+	}
+
+	if err := a.restartExpression(id, expression, vars, priority); err != nil {
+		a.markExpressionFailed(id, err.Error())
+	} else {
+		loggerFrom(r.Context()).Info("выражение перезапущено", "expression_id", id, "status", "pending")
+	}
+
+	expr, _ := a.store.Get(id)
+	writeJSON(w, http.StatusOK, a.view(r.Context(), expr, a.config.ResultFormat))
+}
+
+// Stats – сводка по выражениям. AvgDurationMs – среднее время от приёма
+// до результата у посчитанных выражений
+type Stats struct {
+	Total         int            `json:"total"`
+	ByStatus      map[string]int `json:"by_status"`
+	AvgDurationMs float64        `json:"avg_duration_ms"`
+	QueueLen      int            `json:"queue_len"`
+}
+
+// GetStatsHandler – сводка по хранилищу и длина очереди задач
+func (a *Application) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	// List отдаёт снимок, собранный под RLock хранилища
+	expressions := a.store.List()
+	stats := Stats{
+		Total:    len(expressions),
+		ByStatus: make(map[string]int, len(expressionStatuses)),
+		QueueLen: a.tasks.Len(),
+	}
+	for _, status := range expressionStatuses {
+		stats.ByStatus[status] = 0
+	}
+
+	var total time.Duration
+	completed := 0
+	for _, expr := range expressions {
+		stats.ByStatus[expr.Status]++
+		// У выражений из баз старых версий времени нет
+		if expr.Status != "completed" || expr.CreatedAt.IsZero() || expr.UpdatedAt.IsZero() {
+			continue
+		}
+		total += expr.UpdatedAt.Sub(expr.CreatedAt)
+		completed++
+	}
+	if completed > 0 {
+		stats.AvgDurationMs = float64(total.Milliseconds()) / float64(completed)
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// HealthHandler – liveness-проба: сервер жив и отвечает. Хранилище не трогает
+func (a *Application) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyHandler – readiness-проба: 503, пока хранилище недоступно или очередь
+// задач не создана, иначе 200
+func (a *Application) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if a.tasks == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": "task queue is not initialized"})
+		return
+	}
+	if err := a.store.Ping(); err != nil {
+		loggerFrom(r.Context()).Warn("хранилище недоступно", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": "store is unavailable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// maxTaskWait – предельное время long-poll ожидания задачи
+const maxTaskWait = time.Minute
+
+// GetTaskHandler – выдача очередной задачи внешнему агенту. С параметром
+// wait (например, ?wait=30s) при пустой очереди запрос ждёт появления задачи
+// не дольше этого времени, а не сразу отвечает 404. Параметр ops (например,
+// ?ops=%2B,-) – операции, которые считает агент; без него выдаётся любая задача
+func (a *Application) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	var wait time.Duration
+	if value := r.URL.Query().Get("wait"); value != "" {
+		var err error
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 || wait > maxTaskWait {
+			writeError(w, http.StatusBadRequest, "invalid wait, expected duration up to "+maxTaskWait.String())
+			return
+		}
+	}
+
+	ops := parseOperations(r.URL.Query().Get("ops"))
+
+	task, found := a.getNextTaskToProcess(ops...)
+	if !found && wait > 0 {
+		task, found = a.waitForTask(r.Context(), wait, ops...)
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "no task available")
+		return
+	}
+	a.graph.lease(task.ID, time.Now().Add(a.config.VisibilityTimeout))
+
+	injectTaskTrace(w.Header(), task)
+	writeJSON(w, http.StatusOK, task)
+}
+
+// SubmitResultHandler – обработчик POST-запроса с результатом вычисления задачи от агента
+func (a *Application) SubmitResultHandler(w http.ResponseWriter, r *http.Request) {
+	var res Result
+	if !a.readJSON(w, r, &res, "invalid result payload") {
+		return
+	}
+
+	if !a.completeTask(res) {
+		writeError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	a.metrics.observeResult("external", res)
+
+	loggerFrom(r.Context()).Info("получен результат задачи", "task_id", res.ID, "result", res.Result, "error", res.Error)
+	writeJSON(w, http.StatusOK, map[string]string{"id": res.ID})
+}
@@ -0,0 +1,83 @@
+package application
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// expressionTimeoutError – причина ошибки выражения, не посчитанного за EXPRESSION_TIMEOUT
+const expressionTimeoutError = "timeout"
+
+// expireStaleExpressions – перевод в "error" выражений, которые считаются
+// дольше ExpressionTimeout с начала вычисления (StartedAt, у записей без него –
+// CreatedAt). Их узлы убираются из графа, поэтому задачи из очереди
+// пропускаются, а поздние результаты отклоняются
+func (a *Application) expireStaleExpressions(now time.Time) {
+	deadline := now.Add(-a.config.ExpressionTimeout)
+	for _, expr := range a.store.List() {
+		started := expr.StartedAt
+		if started.IsZero() {
+			started = expr.CreatedAt
+		}
+		if isFinished(expr.Status) || started.IsZero() || started.After(deadline) {
+			continue
+		}
+		var expired bool
+		a.updateExpression(expr.ID, func(expr *Expression) {
+			// Выражение могло завершиться после снимка List
+			if !isFinished(expr.Status) {
+				expr.Status = "error"
+				expr.Error = expressionTimeoutError
+				expired = true
+			}
+		})
+		if !expired {
+			continue
+		}
+		a.graph.remove(expr.ID)
+		a.releaseActive(expr.ID)
+		slog.Warn("выражение не посчитано вовремя", "expression_id", expr.ID, "status", "error", "timeout", a.config.ExpressionTimeout)
+		a.deliverCallback(expr.ID)
+	}
+}
+
+// deleteExpiredExpressions – удаление завершённых выражений (completed, error,
+// cancelled), которые не менялись дольше ExpressionTTL. Незавершённые не трогаем
+func (a *Application) deleteExpiredExpressions(now time.Time) {
+	deadline := now.Add(-a.config.ExpressionTTL)
+	for _, expr := range a.store.List() {
+		if !isFinished(expr.Status) || expr.UpdatedAt.IsZero() || expr.UpdatedAt.After(deadline) {
+			continue
+		}
+		deleted, err := a.store.Delete(expr.ID)
+		if err != nil {
+			slog.Error("ошибка при удалении выражения", "expression_id", expr.ID, "error", err)
+			continue
+		}
+		if deleted {
+			a.forgetDeleted(expr.ID)
+			a.metrics.expressionsExpired.Inc()
+			slog.Debug("устаревшее выражение удалено", "expression_id", expr.ID, "status", expr.Status)
+		}
+	}
+}
+
+// runJanitor – периодическая уборка хранилища до отмены контекста
+func (a *Application) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(a.config.JanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if a.config.ExpressionTimeout > 0 {
+				a.expireStaleExpressions(now)
+			}
+			if a.config.ExpressionTTL > 0 {
+				a.deleteExpiredExpressions(now)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package application
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics – метрики Prometheus одного экземпляра приложения. У каждого
+// экземпляра свой реестр, чтобы приложения не делили счётчики
+type metrics struct {
+	registry *prometheus.Registry
+
+	// expressionsSubmitted – принятые выражения
+	expressionsSubmitted prometheus.Counter
+	// taskResults – результаты задач по источнику (agent/external) и исходу (ok/error)
+	taskResults *prometheus.CounterVec
+	// divisionByZero – задачи, завершившиеся делением на ноль
+	divisionByZero prometheus.Counter
+	// expressionsExpired – завершённые выражения, удалённые по EXPRESSION_TTL
+	expressionsExpired prometheus.Counter
+	// taskDuration – время вычисления задачи встроенным агентом по операциям
+	taskDuration *prometheus.HistogramVec
+	// resultCache – обращения к кэшу результатов задач по исходу (hit/miss)
+	resultCache *prometheus.CounterVec
+}
+
+// newMetrics – регистрация метрик; число выражений по статусам и длина
+// очереди считаются в момент сбора
+func newMetrics(a *Application) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		expressionsSubmitted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "calc_expressions_submitted_total",
+			Help: "Number of accepted expressions.",
+		}),
+		taskResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calc_task_results_total",
+			Help: "Number of task results by source and outcome.",
+		}, []string{"source", "outcome"}),
+		divisionByZero: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "calc_division_by_zero_total",
+			Help: "Number of tasks failed with division by zero.",
+		}),
+		expressionsExpired: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "calc_expressions_expired_total",
+			Help: "Number of finished expressions deleted after their TTL.",
+		}),
+		taskDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "calc_task_duration_seconds",
+			Help:    "Time spent by the built-in agent on a task.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation"}),
+		resultCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calc_result_cache_lookups_total",
+			Help: "Number of task result cache lookups by outcome (hit/miss).",
+		}, []string{"outcome"}),
+	}
+	m.registry.MustRegister(m.expressionsSubmitted, m.taskResults, m.divisionByZero, m.expressionsExpired, m.taskDuration, m.resultCache)
+
+	for _, status := range expressionStatuses {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "calc_expressions",
+			Help:        "Number of stored expressions by status.",
+			ConstLabels: prometheus.Labels{"status": status},
+		}, func() float64 {
+			count := 0
+			for _, expr := range a.store.List() {
+				if expr.Status == status {
+					count++
+				}
+			}
+			return float64(count)
+		}))
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "calc_task_queue_length",
+		Help: "Number of tasks waiting in the queue.",
+	}, func() float64 {
+		return float64(a.tasks.Len())
+	}))
+	return m
+}
+
+// handler – отдача метрик в формате Prometheus
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeResult – учёт результата задачи, присланного агентом source
+func (m *metrics) observeResult(source string, res Result) {
+	outcome := "ok"
+	if res.Error != "" {
+		outcome = "error"
+	}
+	m.taskResults.WithLabelValues(source, outcome).Inc()
+	if strings.Contains(res.Error, calculation.ErrDivisionByZero.Error()) {
+		m.divisionByZero.Inc()
+	}
+}
@@ -0,0 +1,119 @@
+package application
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// plainTexter – ответ, у которого кроме JSON есть запись простым текстом
+type plainTexter interface {
+	PlainText() string
+}
+
+// respond – ответ в формате, который клиент просит заголовком Accept: JSON по
+// умолчанию или text/plain, если у v есть текстовая запись. Если клиент не
+// принимает ни один из них, отвечаем 406
+func respond(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	offers := []string{"application/json"}
+	text, hasText := v.(plainTexter)
+	if hasText {
+		offers = append(offers, "text/plain")
+	}
+	w.Header().Add("Vary", "Accept")
+
+	switch negotiate(r.Header.Get("Accept"), offers) {
+	case "application/json":
+		writeJSON(w, status, v)
+	case "text/plain":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		if _, err := io.WriteString(w, text.PlainText()+"\n"); err != nil {
+			slog.Error("ошибка при отправке ответа", "error", err)
+		}
+	default:
+		writeError(w, http.StatusNotAcceptable, "not acceptable, supported types: "+strings.Join(offers, ", "))
+	}
+}
+
+// negotiate – тип из offers, который клиент предпочитает по заголовку Accept.
+// При равном весе выигрывает тип, стоящий в offers раньше; пустой Accept
+// означает первый тип. Пустая строка – клиент не принимает ни один
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality – вес q, с которым Accept принимает тип offer. Из подходящих
+// диапазонов берётся самый точный: "text/plain" важнее "text/*", а тот – "*/*"
+func acceptQuality(accept, offer string) float64 {
+	offerType, _, _ := strings.Cut(offer, "/")
+	q, specificity := 0.0, 0
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		rangeType, rangeSubtype, _ := strings.Cut(mediaRange, "/")
+		var s int
+		switch {
+		case mediaRange == offer:
+			s = 3
+		case rangeType == offerType && rangeSubtype == "*":
+			s = 2
+		case mediaRange == "*/*":
+			s = 1
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = min(max(parsed, 0), 1)
+			}
+		}
+	}
+	return q
+}
+
+// PlainText – выражение простым текстом: "2 + 2 = 4 (completed)" для
+// посчитанного, "1 / 0: division by zero (error)" для ошибки и
+// "2 + 2 (pending)" для остальных статусов
+func (e Expression) PlainText() string {
+	return e.plainText(formatNumber(e.Result))
+}
+
+// plainText – текстовая запись выражения с уже записанным итогом result
+func (e Expression) plainText(result string) string {
+	switch {
+	case e.Status == "completed":
+		return e.Expression + " = " + result + " (" + e.Status + ")"
+	case e.Error != "":
+		return e.Expression + ": " + e.Error + " (" + e.Status + ")"
+	}
+	return e.Expression + " (" + e.Status + ")"
+}
+
+// formatNumber – запись числа так же, как в JSON-ответах
+func formatNumber(x float64) string {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+	return string(data)
+}
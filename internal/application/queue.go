@@ -0,0 +1,180 @@
+package application
+
+import (
+	"container/heap"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// TaskQueue – потокобезопасная очередь готовых к выдаче задач. Задачи
+// разложены по отдельным очередям своих операций, чтобы агент, который
+// считает только некоторые операции, получал задачу, не дожидаясь долгих
+// чужих. Первой выдаётся задача с наибольшим Priority, при равном приоритете –
+// поставленная раньше. Ёмкость общая на все операции и ограничивает только
+// приём новых выражений (PushWait); задачи, ставшие готовыми по ходу
+// вычисления, ставятся всегда (Push)
+type TaskQueue struct {
+	mu sync.Mutex
+	// byOperation – очереди задач по операциям, size – задач во всех вместе
+	byOperation map[string]*taskHeap
+	size        int
+	seq         uint64
+	capacity    int
+	// ready закрывается, когда появилась задача: ждущих с разными операциями
+	// будят все сразу. space – пробуждение ждущих места
+	ready chan struct{}
+	space chan struct{}
+}
+
+// NewTaskQueue – очередь ёмкостью capacity задач; меньше одной быть не может
+func NewTaskQueue(capacity int) *TaskQueue {
+	return &TaskQueue{
+		byOperation: make(map[string]*taskHeap),
+		capacity:    max(capacity, 1),
+		ready:       make(chan struct{}),
+		space:       make(chan struct{}, 1),
+	}
+}
+
+// PushWait – постановка задачи с учётом ёмкости: при заполненной очереди
+// ждёт места до deadline или отмены ctx. false, если места не дождались
+func (q *TaskQueue) PushWait(ctx context.Context, task Task, deadline <-chan time.Time) bool {
+	for {
+		q.mu.Lock()
+		if q.size < q.capacity {
+			q.add(task)
+			q.mu.Unlock()
+			return true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.space:
+		case <-deadline:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// Push – постановка задачи без учёта ёмкости
+func (q *TaskQueue) Push(task Task) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.add(task)
+}
+
+func (q *TaskQueue) add(task Task) {
+	q.seq++
+	items, found := q.byOperation[task.Operation]
+	if !found {
+		items = &taskHeap{}
+		q.byOperation[task.Operation] = items
+	}
+	heap.Push(items, queuedTask{task: task, seq: q.seq})
+	q.size++
+	close(q.ready)
+	q.ready = make(chan struct{})
+}
+
+// Pop – задача с наибольшим приоритетом среди операций ops; без ops – среди
+// всех. false, если подходящих задач нет
+func (q *TaskQueue) Pop(ops ...string) (Task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var best *taskHeap
+	for op, items := range q.byOperation {
+		if items.Len() == 0 || len(ops) > 0 && !slices.Contains(ops, op) {
+			continue
+		}
+		if best == nil || (*items)[0].before((*best)[0]) {
+			best = items
+		}
+	}
+	if best == nil {
+		return Task{}, false
+	}
+	task := heap.Pop(best).(queuedTask).task
+	q.size--
+	wake(q.space)
+	return task, true
+}
+
+// Wait – как Pop, но при отсутствии подходящих задач ждёт их не дольше wait
+// или до отмены ctx
+func (q *TaskQueue) Wait(ctx context.Context, wait time.Duration, ops ...string) (Task, bool) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		// Канал берётся до Pop, чтобы не пропустить задачу, поставленную между ними
+		q.mu.Lock()
+		ready := q.ready
+		q.mu.Unlock()
+		if task, ok := q.Pop(ops...); ok {
+			return task, true
+		}
+		select {
+		case <-ready:
+		case <-timer.C:
+			return Task{}, false
+		case <-ctx.Done():
+			return Task{}, false
+		}
+	}
+}
+
+// Len – число задач в очереди
+func (q *TaskQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// Cap – ёмкость очереди для PushWait
+func (q *TaskQueue) Cap() int {
+	return q.capacity
+}
+
+// wake – неблокирующее пробуждение одного ждущего
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// queuedTask – задача с порядковым номером постановки
+type queuedTask struct {
+	task Task
+	seq  uint64
+}
+
+// before – задача выдаётся раньше other: у неё выше приоритет или, при
+// равном, она поставлена раньше
+func (t queuedTask) before(other queuedTask) bool {
+	if t.task.Priority != other.task.Priority {
+		return t.task.Priority > other.task.Priority
+	}
+	return t.seq < other.seq
+}
+
+// taskHeap – куча задач для container/heap
+type taskHeap []queuedTask
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+
+func (h taskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x interface{}) { *h = append(*h, x.(queuedTask)) }
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,102 @@
+package application
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets – после стольких клиентов полностью восполненные корзины удаляются
+const maxIdleBuckets = 10000
+
+// bucket – корзина токенов одного клиента
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter – token bucket по ключу клиента: rps токенов в секунду,
+// не больше burst накопленных
+type rateLimiter struct {
+	mu    sync.Mutex
+	rps   float64
+	burst float64
+	// byKey – клиенты различаются по Authorization (см. clientKey)
+	byKey   bool
+	buckets map[string]*bucket
+}
+
+func newRateLimiter(rps int, byKey bool) *rateLimiter {
+	return &rateLimiter{
+		rps:     float64(rps),
+		burst:   float64(rps),
+		byKey:   byKey,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow – забирает токен клиента key. Если токенов нет, возвращает, через
+// сколько появится следующий
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, found := l.buckets[key]
+	if !found {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.dropFullLocked(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// dropFullLocked – удаление корзин, которые успели восполниться: такие
+// клиенты ничем не отличаются от новых
+func (l *rateLimiter) dropFullLocked(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rps >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// middleware – 429 с Retry-After, когда клиент превысил лимит. Клиент
+// определяется по ключу из Authorization, если он проверяется, иначе – по IP
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow(clientKey(r, l.byKey))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey – ключ клиента для лимита запросов. Authorization учитывается
+// только при byKey, то есть когда задан API_KEY и заголовок уже проверен:
+// иначе клиент обходил бы лимиты, меняя заголовок в каждом запросе
+func clientKey(r *http.Request, byKey bool) string {
+	if auth := r.Header.Get("Authorization"); byKey && auth != "" {
+		return "key:" + auth
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
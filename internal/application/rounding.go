@@ -0,0 +1,86 @@
+package application
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Режимы округления результата деления
+const (
+	// roundingRound – к ближайшему, половина – от нуля
+	roundingRound = "round"
+	// roundingFloor – вниз, к минус бесконечности
+	roundingFloor = "floor"
+	// roundingCeil – вверх, к плюс бесконечности
+	roundingCeil = "ceil"
+	// roundingTruncate – отбрасывание лишних знаков, к нулю
+	roundingTruncate = "truncate"
+)
+
+// validRoundingMode – режим округления поддерживается
+func validRoundingMode(mode string) bool {
+	switch mode {
+	case roundingRound, roundingFloor, roundingCeil, roundingTruncate:
+		return true
+	}
+	return false
+}
+
+// RoundDivision – результат деления, округлённый до DivisionPrecision знаков
+// после запятой в режиме DivisionRounding. Так 10 / 3 всегда даёт одну и ту же
+// запись, а не 3.3333333333333335
+func (c *Config) RoundDivision(x float64) float64 {
+	return roundDecimal(x, c.DivisionPrecision, c.DivisionRounding)
+}
+
+// roundDecimal – округление десятичной записи x до digits знаков после запятой.
+// Округляется кратчайшая десятичная запись числа, а не двоичное значение,
+// поэтому 0.29 вниз до двух знаков остаётся 0.29. При отрицательном digits,
+// а также для NaN и бесконечностей x возвращается как есть
+func roundDecimal(x float64, digits int, mode string) float64 {
+	if digits < 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+		return x
+	}
+	whole, frac, _ := strings.Cut(strconv.FormatFloat(x, 'f', -1, 64), ".")
+	if len(frac) <= digits {
+		// Лишних знаков нет – число уже точное
+		return x
+	}
+	kept := whole
+	if digits > 0 {
+		kept += "." + frac[:digits]
+	}
+	rounded := parseDecimal(kept, x)
+
+	// Отброшенные знаки не нулевые; решаем, нужен ли шаг от нуля
+	var away bool
+	switch mode {
+	case roundingRound:
+		// Половина округляется от нуля: 0.125 до двух знаков – 0.13
+		away = frac[digits] >= '5'
+	case roundingFloor:
+		away = x < 0
+	case roundingCeil:
+		away = x > 0
+	}
+	if away {
+		rounded += math.Copysign(math.Pow10(-digits), x)
+	}
+	// Сумма с шагом может дать 3.3400000000000003 – оставляем только digits знаков
+	rounded = parseDecimal(strconv.FormatFloat(rounded, 'f', digits, 64), x)
+	if rounded == 0 {
+		// -0.001 к нулю – это 0, а не -0
+		return 0
+	}
+	return rounded
+}
+
+// parseDecimal – число из десятичной записи или fallback, если запись не разобралась
+func parseDecimal(s string, fallback float64) float64 {
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fallback
+	}
+	return x
+}
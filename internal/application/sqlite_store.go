@@ -0,0 +1,315 @@
+package application
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const createExpressionsTable = `CREATE TABLE IF NOT EXISTS expressions (
+	id              TEXT PRIMARY KEY,
+	expression      TEXT NOT NULL,
+	status          TEXT NOT NULL,
+	result          REAL NOT NULL DEFAULT 0,
+	error           TEXT NOT NULL DEFAULT '',
+	total_tasks     INTEGER NOT NULL DEFAULT 0,
+	completed_tasks INTEGER NOT NULL DEFAULT 0,
+	progress        REAL NOT NULL DEFAULT 0,
+	created_at      TEXT NOT NULL DEFAULT '',
+	updated_at      TEXT NOT NULL DEFAULT '',
+	callback_url    TEXT NOT NULL DEFAULT '',
+	priority        INTEGER NOT NULL DEFAULT 0,
+	vars            TEXT NOT NULL DEFAULT '',
+	started_at      TEXT NOT NULL DEFAULT ''
+)`
+
+const createIdempotencyTable = `CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key           TEXT PRIMARY KEY,
+	fingerprint   TEXT NOT NULL,
+	expression_id TEXT NOT NULL,
+	expires_at    TEXT NOT NULL
+)`
+
+// addedColumns – столбцы, которых нет в базах, созданных прежними версиями
+var addedColumns = map[string]string{
+	"created_at":   `ALTER TABLE expressions ADD COLUMN created_at TEXT NOT NULL DEFAULT ''`,
+	"updated_at":   `ALTER TABLE expressions ADD COLUMN updated_at TEXT NOT NULL DEFAULT ''`,
+	"callback_url": `ALTER TABLE expressions ADD COLUMN callback_url TEXT NOT NULL DEFAULT ''`,
+	"priority":     `ALTER TABLE expressions ADD COLUMN priority INTEGER NOT NULL DEFAULT 0`,
+	"vars":         `ALTER TABLE expressions ADD COLUMN vars TEXT NOT NULL DEFAULT ''`,
+	"started_at":   `ALTER TABLE expressions ADD COLUMN started_at TEXT NOT NULL DEFAULT ''`,
+}
+
+const upsertExpression = `INSERT INTO expressions
+	(id, expression, status, result, error, total_tasks, completed_tasks, progress, created_at, updated_at, callback_url, priority, vars, started_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+	expression = excluded.expression,
+	status = excluded.status,
+	result = excluded.result,
+	error = excluded.error,
+	total_tasks = excluded.total_tasks,
+	completed_tasks = excluded.completed_tasks,
+	progress = excluded.progress,
+	created_at = excluded.created_at,
+	updated_at = excluded.updated_at,
+	callback_url = excluded.callback_url,
+	priority = excluded.priority,
+	vars = excluded.vars,
+	started_at = excluded.started_at`
+
+// SQLiteStore – хранилище выражений в SQLite. При открытии таблица expressions
+// читается в память, чтение идёт из памяти, а каждое изменение сразу
+// записывается в базу, поэтому выражения переживают перезапуск сервера
+type SQLiteStore struct {
+	// mu упорядочивает запись, чтобы в базе не оказалась устаревшая версия выражения
+	mu     sync.Mutex
+	memory *MemoryStore
+	db     *sql.DB
+}
+
+// NewSQLiteStore – открытие (или создание) базы по пути path
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при открытии базы %s: %w", path, err)
+	}
+	// SQLite не любит параллельную запись, а ":memory:" у каждого соединения свой
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStore{memory: NewMemoryStore(), db: db}
+	if err := s.load(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ошибка при чтении базы %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// load – создание таблицы при первом запуске и чтение сохранённых выражений
+func (s *SQLiteStore) load() error {
+	if _, err := s.db.Exec(createExpressionsTable); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(createIdempotencyTable); err != nil {
+		return err
+	}
+	if err := s.migrate(); err != nil {
+		return err
+	}
+	if err := s.loadIdempotencyKeys(); err != nil {
+		return err
+	}
+	rows, err := s.db.Query(`SELECT id, expression, status, result, error,
+		total_tasks, completed_tasks, progress, created_at, updated_at, callback_url, priority, vars, started_at FROM expressions`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var expr Expression
+		var createdAt, updatedAt, startedAt, vars string
+		if err := rows.Scan(&expr.ID, &expr.Expression, &expr.Status, &expr.Result, &expr.Error,
+			&expr.TotalTasks, &expr.CompletedTasks, &expr.Progress, &createdAt, &updatedAt, &expr.CallbackURL, &expr.Priority, &vars, &startedAt); err != nil {
+			return err
+		}
+		if vars != "" {
+			if err := json.Unmarshal([]byte(vars), &expr.Vars); err != nil {
+				return fmt.Errorf("переменные выражения %s: %w", expr.ID, err)
+			}
+		}
+		// У записей старых версий времени нет – оставляем нулевое
+		expr.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+		expr.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+		expr.StartedAt, _ = time.Parse(time.RFC3339Nano, startedAt)
+		s.memory.Add(&expr)
+	}
+	return rows.Err()
+}
+
+// loadIdempotencyKeys – чтение неистёкших ключей идемпотентности
+func (s *SQLiteStore) loadIdempotencyKeys() error {
+	now := formatTime(time.Now())
+	if _, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at < ?`, now); err != nil {
+		return err
+	}
+	rows, err := s.db.Query(`SELECT key, fingerprint, expression_id, expires_at FROM idempotency_keys`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rec IdempotencyRecord
+		var expiresAt string
+		if err := rows.Scan(&rec.Key, &rec.Fingerprint, &rec.ExpressionID, &expiresAt); err != nil {
+			return err
+		}
+		rec.ExpiresAt, _ = time.Parse(time.RFC3339Nano, expiresAt)
+		s.memory.ReserveIdempotencyKey(rec)
+	}
+	return rows.Err()
+}
+
+// migrate – добавление столбцов, которых нет в базе прежней версии
+func (s *SQLiteStore) migrate() error {
+	rows, err := s.db.Query(`SELECT name FROM pragma_table_info('expressions')`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for column, statement := range addedColumns {
+		if existing[column] {
+			continue
+		}
+		if _, err := s.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save – запись текущего состояния выражения в базу
+func (s *SQLiteStore) save(expr Expression) error {
+	var vars string
+	if len(expr.Vars) > 0 {
+		encoded, err := json.Marshal(expr.Vars)
+		if err != nil {
+			return fmt.Errorf("ошибка при сохранении выражения %s: %w", expr.ID, err)
+		}
+		vars = string(encoded)
+	}
+	_, err := s.db.Exec(upsertExpression, expr.ID, expr.Expression, expr.Status, expr.Result, expr.Error,
+		expr.TotalTasks, expr.CompletedTasks, expr.Progress,
+		formatTime(expr.CreatedAt), formatTime(expr.UpdatedAt), expr.CallbackURL, expr.Priority, vars, formatTime(expr.StartedAt))
+	if err != nil {
+		return fmt.Errorf("ошибка при сохранении выражения %s: %w", expr.ID, err)
+	}
+	return nil
+}
+
+// formatTime – время для столбца TEXT; нулевое время хранится пустой строкой
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Add – сохранение нового выражения в базе и в памяти
+func (s *SQLiteStore) Add(expr *Expression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(*expr); err != nil {
+		return err
+	}
+	return s.memory.Add(expr)
+}
+
+// Get – выражение по ID
+func (s *SQLiteStore) Get(id string) (Expression, bool) {
+	return s.memory.Get(id)
+}
+
+// List – снимок всех выражений
+func (s *SQLiteStore) List() []Expression {
+	return s.memory.List()
+}
+
+// Each – обход выражений в порядке создания; читается из памяти, как и List
+func (s *SQLiteStore) Each(fn func(expr Expression) error) error {
+	return s.memory.Each(fn)
+}
+
+// Update – изменение выражения в памяти и запись нового состояния в базу
+func (s *SQLiteStore) Update(id string, update func(expr *Expression)) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var updated Expression
+	found, _ := s.memory.Update(id, func(expr *Expression) {
+		update(expr)
+		updated = *expr
+	})
+	if !found {
+		return false, nil
+	}
+	return true, s.save(updated)
+}
+
+// Delete – удаление выражения из базы и из памяти
+func (s *SQLiteStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.db.Exec(`DELETE FROM expressions WHERE id = ?`, id); err != nil {
+		return false, fmt.Errorf("ошибка при удалении выражения %s: %w", id, err)
+	}
+	return s.memory.Delete(id)
+}
+
+// DeleteByStatus – удаление выражений в статусе status из базы и из памяти
+func (s *SQLiteStore) DeleteByStatus(status string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.db.Exec(`DELETE FROM expressions WHERE status = ?`, status); err != nil {
+		return nil, fmt.Errorf("ошибка при удалении выражений в статусе %s: %w", status, err)
+	}
+	return s.memory.DeleteByStatus(status)
+}
+
+// ReserveIdempotencyKey – закрепление ключа в памяти и в базе
+func (s *SQLiteStore) ReserveIdempotencyKey(rec IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, reserved, _ := s.memory.ReserveIdempotencyKey(rec)
+	if !reserved {
+		return existing, false, nil
+	}
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO idempotency_keys (key, fingerprint, expression_id, expires_at)
+		VALUES (?, ?, ?, ?)`, rec.Key, rec.Fingerprint, rec.ExpressionID, formatTime(rec.ExpiresAt))
+	if err != nil {
+		s.memory.ReleaseIdempotencyKey(rec.Key)
+		return IdempotencyRecord{}, false, fmt.Errorf("ошибка при сохранении ключа идемпотентности: %w", err)
+	}
+	return rec, true, nil
+}
+
+// ReleaseIdempotencyKey – освобождение ключа в памяти и в базе
+func (s *SQLiteStore) ReleaseIdempotencyKey(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memory.ReleaseIdempotencyKey(key)
+	if _, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE key = ?`, key); err != nil {
+		return fmt.Errorf("ошибка при удалении ключа идемпотентности: %w", err)
+	}
+	return nil
+}
+
+// Ping – проверка соединения с базой
+func (s *SQLiteStore) Ping() error {
+	return s.db.Ping()
+}
+
+// Subscribe – наблюдение за изменениями выражения
+func (s *SQLiteStore) Subscribe(id string) (<-chan Expression, func()) {
+	return s.memory.Subscribe(id)
+}
+
+// Close – закрытие базы
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,250 @@
+package application
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store – хранилище выражений (CRUD). Чтение отдаёт копии, чтобы вызывающий
+// не читал выражение во время записи; запись может вернуть ошибку хранилища
+type Store interface {
+	// Add – сохранение нового выражения
+	Add(expr *Expression) error
+	// Get – выражение по ID
+	Get(id string) (Expression, bool)
+	// List – снимок всех выражений
+	List() []Expression
+	// Each – обход выражений по одному в порядке создания (CreatedAt, затем ID)
+	// без копирования всего хранилища; ошибка fn прерывает обход
+	Each(fn func(expr Expression) error) error
+	// Update – изменение выражения с отметкой времени UpdatedAt; false, если выражения нет
+	Update(id string, update func(expr *Expression)) (bool, error)
+	// Delete – удаление выражения; false, если выражения нет
+	Delete(id string) (bool, error)
+	// DeleteByStatus – удаление всех выражений в статусе status; ID удалённых
+	DeleteByStatus(status string) ([]string, error)
+	// Subscribe – наблюдение за изменениями выражения: в канал приходит его
+	// последнее состояние после каждого Update, при удалении канал закрывается.
+	// cancel отписывается и должен быть вызван
+	Subscribe(id string) (updates <-chan Expression, cancel func())
+	// ReserveIdempotencyKey – закрепление Idempotency-Key за выражением. Если
+	// у ключа уже есть неистёкшая запись, возвращает её и false
+	ReserveIdempotencyKey(rec IdempotencyRecord) (IdempotencyRecord, bool, error)
+	// ReleaseIdempotencyKey – освобождение ключа, если выражение не удалось принять
+	ReleaseIdempotencyKey(key string) error
+	// Ping – проверка, что хранилище доступно
+	Ping() error
+	// Close – освобождение ресурсов хранилища
+	Close() error
+}
+
+// IdempotencyRecord – выражение, созданное запросом с Idempotency-Key.
+// Fingerprint – отпечаток тела запроса: тот же ключ с другим телом отклоняется
+type IdempotencyRecord struct {
+	Key          string
+	Fingerprint  string
+	ExpressionID string
+	ExpiresAt    time.Time
+}
+
+// MemoryStore – хранилище выражений в памяти; всё теряется при перезапуске
+type MemoryStore struct {
+	mu          sync.RWMutex
+	expressions map[string]*Expression
+	idempotency map[string]IdempotencyRecord
+	watchers    map[string][]chan Expression
+}
+
+// NewMemoryStore – создание пустого хранилища в памяти
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		expressions: make(map[string]*Expression),
+		idempotency: make(map[string]IdempotencyRecord),
+		watchers:    make(map[string][]chan Expression),
+	}
+}
+
+// Add – сохранение копии нового выражения
+func (s *MemoryStore) Add(expr *Expression) error {
+	stored := *expr
+	s.mu.Lock()
+	s.expressions[stored.ID] = &stored
+	s.mu.Unlock()
+	return nil
+}
+
+// Get – копия выражения по ID
+func (s *MemoryStore) Get(id string) (Expression, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	expr, found := s.expressions[id]
+	if !found {
+		return Expression{}, false
+	}
+	return *expr, true
+}
+
+// List – снимок всех выражений. Запись держит блокировку только на время
+// обновления полей, поэтому список доступен и во время вычислений
+func (s *MemoryStore) List() []Expression {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Expression, 0, len(s.expressions))
+	for _, expr := range s.expressions {
+		list = append(list, *expr)
+	}
+	return list
+}
+
+// Each – обход выражений в порядке создания. Под блокировкой запоминаются
+// только ID и время создания, а каждое выражение копируется перед вызовом fn,
+// поэтому медленный fn не задерживает запись. Удалённые за время обхода
+// выражения пропускаются
+func (s *MemoryStore) Each(fn func(expr Expression) error) error {
+	type key struct {
+		id      string
+		created time.Time
+	}
+	s.mu.RLock()
+	keys := make([]key, 0, len(s.expressions))
+	for id, expr := range s.expressions {
+		keys = append(keys, key{id: id, created: expr.CreatedAt})
+	}
+	s.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].created.Equal(keys[j].created) {
+			return keys[i].created.Before(keys[j].created)
+		}
+		return keys[i].id < keys[j].id
+	})
+
+	for _, k := range keys {
+		expr, found := s.Get(k.id)
+		if !found {
+			continue
+		}
+		if err := fn(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update – изменение выражения под блокировкой
+func (s *MemoryStore) Update(id string, update func(expr *Expression)) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expr, found := s.expressions[id]
+	if found {
+		update(expr)
+		expr.UpdatedAt = time.Now().UTC()
+		for _, ch := range s.watchers[id] {
+			notify(ch, *expr)
+		}
+	}
+	return found, nil
+}
+
+// Delete – удаление выражения
+func (s *MemoryStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, found := s.expressions[id]
+	delete(s.expressions, id)
+	for _, ch := range s.watchers[id] {
+		close(ch)
+	}
+	delete(s.watchers, id)
+	return found, nil
+}
+
+// DeleteByStatus – удаление выражений в статусе status одним проходом под
+// блокировкой, поэтому выражение не сменит статус между проверкой и удалением
+func (s *MemoryStore) DeleteByStatus(status string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted []string
+	for id, expr := range s.expressions {
+		if expr.Status != status {
+			continue
+		}
+		delete(s.expressions, id)
+		for _, ch := range s.watchers[id] {
+			close(ch)
+		}
+		delete(s.watchers, id)
+		deleted = append(deleted, id)
+	}
+	return deleted, nil
+}
+
+// Subscribe – наблюдение за изменениями выражения. Канал хранит только
+// последнее состояние, поэтому медленный читатель не задерживает запись
+func (s *MemoryStore) Subscribe(id string) (<-chan Expression, func()) {
+	ch := make(chan Expression, 1)
+	s.mu.Lock()
+	s.watchers[id] = append(s.watchers[id], ch)
+	s.mu.Unlock()
+
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		watchers := s.watchers[id]
+		for i, watcher := range watchers {
+			if watcher == ch {
+				s.watchers[id] = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+		if len(s.watchers[id]) == 0 {
+			delete(s.watchers, id)
+		}
+	}
+	return ch, cancel
+}
+
+// notify – замена непрочитанного состояния в канале наблюдателя новым.
+// Вызывается под блокировкой записи, поэтому других писателей в канал нет
+func notify(ch chan Expression, expr Expression) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- expr
+}
+
+// ReserveIdempotencyKey – закрепление ключа; истёкшие записи заодно удаляются
+func (s *MemoryStore) ReserveIdempotencyKey(rec IdempotencyRecord) (IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, existing := range s.idempotency {
+		if now.After(existing.ExpiresAt) {
+			delete(s.idempotency, key)
+		}
+	}
+	if existing, found := s.idempotency[rec.Key]; found {
+		return existing, false, nil
+	}
+	s.idempotency[rec.Key] = rec
+	return rec, true, nil
+}
+
+// ReleaseIdempotencyKey – освобождение ключа
+func (s *MemoryStore) ReleaseIdempotencyKey(key string) error {
+	s.mu.Lock()
+	delete(s.idempotency, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// Ping – хранилище в памяти доступно всегда
+func (s *MemoryStore) Ping() error {
+	return nil
+}
+
+// Close – хранилищу в памяти освобождать нечего
+func (s *MemoryStore) Close() error {
+	return nil
+}
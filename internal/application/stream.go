@@ -0,0 +1,82 @@
+package application
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// isFinished – выражение больше не изменится
+func isFinished(status string) bool {
+	return status == "completed" || status == "error" || status == "cancelled"
+}
+
+// StreamExpressionHandler – Server-Sent Events с состоянием выражения: первое
+// событие – текущее состояние, дальше – при каждой смене статуса или прогресса.
+// Стрим закрывается после итогового статуса, удаления выражения или по таймауту.
+// Параметр format задаёт запись итога
+func (a *Application) StreamExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	format, ok := a.resultFormat(w, r)
+	if !ok {
+		return
+	}
+
+	// Подписываемся до чтения, чтобы не пропустить изменение между ними
+	updates, cancel := a.store.Subscribe(id)
+	defer cancel()
+
+	expr, found := a.store.Get(id)
+	if !found {
+		writeError(w, http.StatusNotFound, "expression not found")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(expr Expression) bool {
+		data, err := json.Marshal(a.view(r.Context(), expr, format))
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !send(expr) || isFinished(expr.Status) {
+		return
+	}
+	last := expr
+
+	timeout := time.NewTimer(a.config.StreamTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case expr, ok := <-updates:
+			if !ok {
+				// Выражение удалено
+				return
+			}
+			if expr.Status == last.Status && expr.Progress == last.Progress {
+				continue
+			}
+			if !send(expr) || isFinished(expr.Status) {
+				return
+			}
+			last = expr
+		case <-timeout.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
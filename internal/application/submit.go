@@ -0,0 +1,217 @@
+package application
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// idempotencyKeyHeader – заголовок, по которому повтор POST не создаёт новое выражение
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// fingerprint – отпечаток выражения и его переменных для сверки с
+// Idempotency-Key. У запроса без переменных отпечаток – хеш одной записи
+// выражения, как и у ключей, сохранённых прежними версиями
+func fingerprint(req Request) string {
+	h := sha256.New()
+	h.Write([]byte(req.Expression))
+	for _, name := range slices.Sorted(maps.Keys(req.Vars)) {
+		fmt.Fprintf(h, "\x00%s=%v", name, req.Vars[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// submitError – отказ в приёме выражения с HTTP-статусом ответа
+type submitError struct {
+	status  int
+	message string
+	// retryAfter – через сколько повторить запрос (для 503)
+	retryAfter time.Duration
+	// parse – место синтаксической ошибки, если выражение не разобралось
+	parse *calculation.ParseError
+}
+
+// ParseErrorResponse – ответ на выражение, которое не разобралось. Position –
+// байтовое смещение проблемной лексемы (для оборванного выражения – его длина),
+// Token – её текст; по ним клиент может подсветить место ошибки
+type ParseErrorResponse struct {
+	Error    string `json:"error"`
+	Position int    `json:"position"`
+	Token    string `json:"token"`
+}
+
+func (e *submitError) Error() string {
+	return e.message
+}
+
+// writeSubmitError – ответ на отказ в приёме выражения. Если клиент ушёл,
+// не отвечаем ничего
+func writeSubmitError(w http.ResponseWriter, err error) {
+	var rejected *submitError
+	if !errors.As(err, &rejected) {
+		return
+	}
+	if rejected.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rejected.retryAfter.Seconds()))))
+	}
+	if rejected.parse != nil {
+		writeJSON(w, rejected.status, ParseErrorResponse{Error: rejected.message, Position: rejected.parse.Pos, Token: rejected.parse.Token})
+		return
+	}
+	writeError(w, rejected.status, rejected.message)
+}
+
+// submitExpression – проверка выражения, раскладка на задачи, сохранение под
+// ID expressionID и постановка готовых задач в очередь. Выражение занимает одно
+// из мест MaxActiveExpressions клиента из ctx. Возвращает *submitError при
+// отказе или ошибку контекста, если клиент ушёл
+func (a *Application) submitExpression(ctx context.Context, expressionID string, req Request) (err error) {
+	ctx, span := tracer().Start(ctx, "submit expression", trace.WithAttributes(attribute.String("expression.id", expressionID)))
+	defer func() {
+		if err != nil {
+			a.releaseActive(expressionID)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	expression := req.Expression
+	tree, rejected := a.checkRequest(req)
+	if rejected != nil {
+		loggerFrom(ctx).Info("выражение не принято", "expression", expression, "error", rejected.message)
+		return rejected
+	}
+	if rejected := a.acquireActive(ctx, expressionID); rejected != nil {
+		loggerFrom(ctx).Info("выражение не принято", "expression", expression, "error", rejected.message)
+		return rejected
+	}
+
+	// Раскладываем выражение на задачи; результат посчитают агенты
+	ready, total, value, err := a.graph.build(ctx, expressionID, tree, req.Priority, a.config)
+	if err != nil {
+		loggerFrom(ctx).Info("выражение не принято", "expression", expression, "error", err)
+		return buildError(err)
+	}
+
+	now := time.Now().UTC()
+	expr := &Expression{
+		ID:          expressionID,
+		Expression:  expression,
+		Status:      "pending",
+		TotalTasks:  total,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		StartedAt:   now,
+		CallbackURL: req.CallbackURL,
+		Priority:    req.Priority,
+		Vars:        req.Vars,
+	}
+	if total == 0 {
+		// В выражении нет бинарных операций – оно уже посчитано
+		expr.Status = "completed"
+		expr.Result = a.config.RoundResult(value)
+		expr.Progress = 100
+	}
+	if err := a.store.Add(expr); err != nil {
+		a.graph.forget(expressionID)
+		loggerFrom(ctx).Error("ошибка при сохранении выражения", "expression_id", expressionID, "error", err)
+		return &submitError{status: http.StatusInternalServerError, message: "failed to store expression"}
+	}
+
+	// Ставим готовые задачи в очередь агентам; если очередь заполнена, ждём места
+	// не дольше таймаута, после чего убираем выражение и просим клиента повторить запрос
+	timeout := a.config.TaskQueueTimeout
+	deadline := time.After(timeout)
+	for _, task := range ready {
+		if a.completeFromCache(task) || a.tasks.PushWait(ctx, task, deadline) {
+			continue
+		}
+		a.discardExpression(ctx, expressionID)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		loggerFrom(ctx).Warn("канал задач переполнен", "expression_id", expressionID, "timeout", timeout)
+		return &submitError{status: http.StatusServiceUnavailable, message: "task queue is full, retry later", retryAfter: timeout}
+	}
+
+	a.metrics.expressionsSubmitted.Inc()
+	if total == 0 {
+		a.releaseActive(expressionID)
+		a.deliverCallback(expressionID)
+	}
+	return nil
+}
+
+// checkRequest – проверка запроса и разбор выражения; общая для приёма
+// выражения и POST /api/v1/validate
+func (a *Application) checkRequest(req Request) (*calculation.Node, *submitError) {
+	if req.Expression == "" {
+		return nil, &submitError{status: http.StatusUnprocessableEntity, message: `field "expression" is required`}
+	}
+	if req.CallbackURL != "" {
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			return nil, &submitError{status: http.StatusUnprocessableEntity, message: err.Error()}
+		}
+	}
+	if len(req.Expression) > a.config.MaxExpressionLength {
+		return nil, &submitError{status: http.StatusUnprocessableEntity, message: fmt.Sprintf("expression is longer than %d characters", a.config.MaxExpressionLength)}
+	}
+
+	tree, err := parseExpression(req.Expression, req.Vars, a.config.MaxNestingDepth)
+	if err != nil {
+		// Корректный запрос с невалидным выражением; место ошибки уходит в ответ
+		rejected := &submitError{status: http.StatusUnprocessableEntity, message: err.Error()}
+		errors.As(err, &rejected.parse)
+		return nil, rejected
+	}
+	if err := checkOperands(tree, a.config.MaxOperand); err != nil {
+		return nil, &submitError{status: http.StatusUnprocessableEntity, message: err.Error()}
+	}
+	return tree, nil
+}
+
+// checkOperands – каждое число выражения по модулю не больше limit; 0 – без
+// ограничения. Заведомо огромные аргументы отвергаются до постановки задач
+func checkOperands(n *calculation.Node, limit float64) error {
+	if n == nil || limit == 0 {
+		return nil
+	}
+	if n.Kind == calculation.NumberNode && math.Abs(n.Value) > limit {
+		operand := n.Text
+		if operand == "" {
+			operand = formatNumber(n.Value)
+		}
+		return fmt.Errorf("operand %s exceeds the limit of %s", operand, formatNumber(limit))
+	}
+	if err := checkOperands(n.Left, limit); err != nil {
+		return err
+	}
+	return checkOperands(n.Right, limit)
+}
+
+// buildError – отказ, если выражение не раскладывается на задачи (например,
+// константа вне области определения функции) или задач больше MaxTasksPerExpression
+func buildError(err error) *submitError {
+	return &submitError{status: http.StatusUnprocessableEntity, message: err.Error()}
+}
+
+// discardExpression – удаление выражения, которое не удалось поставить в очередь
+func (a *Application) discardExpression(ctx context.Context, id string) {
+	a.graph.forget(id)
+	if _, err := a.store.Delete(id); err != nil {
+		loggerFrom(ctx).Error("ошибка при удалении выражения", "expression_id", id, "error", err)
+	}
+}
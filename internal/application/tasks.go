@@ -0,0 +1,267 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
+)
+
+// Логика обработки задач: выданная задача переводит выражение в статус "processing".
+// Задачи выражений, уже завершившихся ошибкой, отменённых или удалённых, пропускаются:
+// их узлы убраны из графа. ops – операции, которые считает агент; без них
+// агент получает задачу любой операции
+func (a *Application) getNextTaskToProcess(ops ...string) (Task, bool) {
+	for {
+		task, found := a.tasks.Pop(ops...)
+		if !found {
+			return Task{}, false
+		}
+		if a.takeTask(task) {
+			return task, true
+		}
+	}
+}
+
+// waitForTask – как getNextTaskToProcess, но при пустой очереди ждёт задачу
+// не дольше wait или до отмены контекста
+func (a *Application) waitForTask(ctx context.Context, wait time.Duration, ops ...string) (Task, bool) {
+	deadline := time.Now().Add(wait)
+	for {
+		task, found := a.tasks.Wait(ctx, time.Until(deadline), ops...)
+		if !found {
+			return Task{}, false
+		}
+		if a.takeTask(task) {
+			return task, true
+		}
+	}
+}
+
+// takeTask – выдача задачи из очереди; false, если её выражение уже не считается
+func (a *Application) takeTask(task Task) bool {
+	expressionID, found := a.graph.take(task.ID)
+	if !found {
+		return false
+	}
+	a.updateExpression(expressionID, func(expr *Expression) {
+		if expr.Status == "pending" {
+			expr.Status = "processing"
+		}
+	})
+	return true
+}
+
+// enqueue – постановка в очередь задачи, аргументы которой только что посчитаны.
+// Её ставит агент или обработчик результата, поэтому ёмкость очереди не
+// проверяется: выражение уже принято и должно досчитаться. Задача с
+// результатом в кэше в очередь не попадает
+func (a *Application) enqueue(task Task) {
+	if a.completeFromCache(task) {
+		return
+	}
+	a.tasks.Push(task)
+}
+
+// completeTask – учёт результата задачи: подстановка в граф выражения, постановка
+// ставших готовыми задач и запись итога выражения. Результат деления
+// округляется по DivisionRounding, кто бы его ни посчитал – встроенный агент
+// в processTask или внешний. false, если задача неизвестна
+func (a *Application) completeTask(res Result) bool {
+	if res.Error != "" {
+		expressionID, found := a.graph.fail(res.ID, res.Error)
+		if !found {
+			return false
+		}
+		a.markExpressionFailed(expressionID, res.Error)
+		return true
+	}
+
+	if op, found := a.graph.operation(res.ID); found && op == "/" {
+		res.Result = a.config.RoundDivision(res.Result)
+	}
+	st, found := a.graph.complete(res.ID, res.Result)
+	if !found {
+		return false
+	}
+	if a.results != nil {
+		a.results.add(st.task, res.Result)
+	}
+	a.updateExpression(st.expressionID, func(expr *Expression) {
+		expr.taskCompleted()
+	})
+	switch {
+	case st.err != nil:
+		a.markExpressionFailed(st.expressionID, st.err.Error())
+	case st.done:
+		a.updateExpression(st.expressionID, func(expr *Expression) {
+			if expr.Status == "cancelled" {
+				return
+			}
+			expr.Status = "completed"
+			expr.Result = a.config.RoundResult(st.result)
+			expr.Error = ""
+		})
+		a.releaseActive(st.expressionID)
+		slog.Info("выражение посчитано", "expression_id", st.expressionID, "status", "completed", "result", a.config.RoundResult(st.result))
+		a.deliverCallback(st.expressionID)
+	default:
+		for _, task := range st.ready {
+			a.enqueue(task)
+		}
+	}
+	return true
+}
+
+// Функция для выполнения вычислений
+func (a *Application) processTask(task Task) {
+	// Эмулируем длительность операции
+	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+
+	var result float64
+	switch task.Operation {
+	case "+":
+		result = task.Arg1 + task.Arg2
+	case "-":
+		result = task.Arg1 - task.Arg2
+	case "*":
+		result = task.Arg1 * task.Arg2
+	case "/":
+		if task.Arg2 == 0 {
+			slog.Warn("деление на ноль", "task_id", task.ID, "operation", task.Operation)
+			a.finishTask(Result{ID: task.ID, Error: calculation.ErrDivisionByZero.Error()})
+			return
+		}
+		result = task.Arg1 / task.Arg2
+	case "^", "%", "//", "<", ">", "<=", ">=", "==", "!=":
+		var err error
+		result, err = calculation.Apply(task.Operation, task.Arg1, task.Arg2)
+		if err != nil {
+			slog.Warn("ошибка вычисления задачи", "task_id", task.ID, "operation", task.Operation, "error", err)
+			a.finishTask(Result{ID: task.ID, Error: err.Error()})
+			return
+		}
+	default:
+		slog.Warn("неподдерживаемая операция", "task_id", task.ID, "operation", task.Operation)
+		a.finishTask(Result{ID: task.ID, Error: fmt.Sprintf("%v: %s", calculation.ErrUnsupportedOperator, task.Operation)})
+		return
+	}
+
+	// Проверяем результат до записи: бесконечность означает переполнение float64
+	if calculation.Overflowed(result) {
+		slog.Warn("переполнение при вычислении задачи", "task_id", task.ID, "operation", task.Operation, "arg1", task.Arg1, "arg2", task.Arg2)
+		a.finishTask(Result{ID: task.ID, Error: calculation.ErrOverflow.Error()})
+		return
+	}
+	if math.IsNaN(result) {
+		slog.Warn("результат задачи не конечное число", "task_id", task.ID, "operation", task.Operation, "result", result)
+		a.finishTask(Result{ID: task.ID, Error: fmt.Sprintf("result is not a finite number: %v", result)})
+		return
+	}
+
+	// Подставляем результат в граф; итог выражения запишется, когда посчитан корень
+	a.finishTask(Result{ID: task.ID, Result: result})
+
+	slog.Debug("задача обработана", "task_id", task.ID, "operation", task.Operation, "result", result)
+}
+
+// finishTask – учёт результата, посчитанного встроенным агентом
+func (a *Application) finishTask(res Result) {
+	a.metrics.observeResult("agent", res)
+	a.completeTask(res)
+}
+
+// restoreExpressions – повторная постановка задач выражений, которые остались
+// в статусе "pending" или "processing" после перезапуска. Граф задач жил только
+// в памяти, поэтому выражение раскладывается заново и считается с начала
+func (a *Application) restoreExpressions() {
+	for _, saved := range a.store.List() {
+		if saved.Status != "pending" && saved.Status != "processing" {
+			continue
+		}
+		if err := a.restartExpression(saved.ID, saved.Expression, saved.Vars, saved.Priority); err != nil {
+			a.markExpressionFailed(saved.ID, err.Error())
+			continue
+		}
+		slog.Info("выражение восстановлено после перезапуска", "expression_id", saved.ID, "status", "pending")
+	}
+}
+
+// restartExpression – раскладка выражения на задачи заново: прогресс, итог
+// и ошибка сбрасываются, выражение возвращается в "pending", а EXPRESSION_TIMEOUT
+// отсчитывается заново
+func (a *Application) restartExpression(id, expression string, vars map[string]float64, priority int) error {
+	tree, err := parseExpression(expression, vars, a.config.MaxNestingDepth)
+	if err != nil {
+		return err
+	}
+	ready, total, value, err := a.graph.build(context.Background(), id, tree, priority, a.config)
+	if err != nil {
+		return err
+	}
+	a.updateExpression(id, func(expr *Expression) {
+		expr.Status = "pending"
+		expr.Result = 0
+		expr.Error = ""
+		expr.TotalTasks = total
+		expr.CompletedTasks = 0
+		expr.Progress = 0
+		expr.StartedAt = time.Now().UTC()
+		if total == 0 {
+			expr.Status = "completed"
+			expr.Result = a.config.RoundResult(value)
+			expr.Progress = 100
+		}
+	})
+	for _, task := range ready {
+		a.enqueue(task)
+	}
+	return nil
+}
+
+// markExpressionFailed – переводит выражение в статус "error" с текстом причины
+func (a *Application) markExpressionFailed(id, reason string) {
+	a.updateExpression(id, func(expr *Expression) {
+		if expr.Status == "cancelled" {
+			return
+		}
+		expr.Status = "error"
+		expr.Error = reason
+	})
+	a.releaseActive(id)
+	slog.Info("выражение завершилось ошибкой", "expression_id", id, "status", "error", "error", reason)
+	a.deliverCallback(id)
+}
+
+// updateExpression – изменение выражения по ходу вычисления. Ошибку хранилища
+// некому вернуть, поэтому она только логируется: в памяти состояние уже новое
+func (a *Application) updateExpression(id string, update func(expr *Expression)) {
+	if _, err := a.store.Update(id, update); err != nil {
+		slog.Error("ошибка при обновлении выражения", "expression_id", id, "error", err)
+	}
+}
+
+// Запуск агента для обработки задач; агент завершается при отмене контекста
+func (a *Application) startAgent(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		// Ждём задачу из очереди; раз в секунду проверяем, не пора ли остановиться
+		task, found := a.waitForTask(ctx, time.Second)
+		if !found {
+			continue
+		}
+		start := time.Now()
+		_, span := startTaskSpan(ctx, task)
+		a.processTask(task)
+		span.End()
+		a.metrics.taskDuration.WithLabelValues(task.Operation).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,87 @@
+package application
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Сквозная трассировка выражения: span HTTP-запроса POST /api/v1/calculate,
+// дочерний span приёма выражения, контекст которого задачи несут через
+// очередь, span обработки задачи агентом и span запроса с её результатом.
+// Внешнему агенту контекст передаётся в заголовке traceparent ответа
+// GET /internal/task, обратно – в заголовке запроса с результатом
+
+// tracerName – имя трассировщика оркестратора
+const tracerName = "github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/application"
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// tracingMiddleware – span на HTTP-запрос, продолжающий трассировку из
+// заголовка traceparent. Span называется по шаблону маршрута, а не по пути,
+// чтобы ID выражений не плодили имён
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				name = template
+			}
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// traceCarrier – контекст трассировки из ctx в виде заголовков traceparent/tracestate
+func traceCarrier(ctx context.Context) propagation.MapCarrier {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// traceContext – ctx с контекстом трассировки, в котором задача была поставлена
+func (t Task) traceContext(ctx context.Context) context.Context {
+	if t.trace == nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, t.trace)
+}
+
+// injectTaskTrace – передача контекста задачи внешнему агенту в заголовках ответа
+func injectTaskTrace(header http.Header, task Task) {
+	otel.GetTextMapPropagator().Inject(task.traceContext(context.Background()), propagation.HeaderCarrier(header))
+}
+
+// startTaskSpan – span обработки задачи встроенным агентом, дочерний к span
+// приёма её выражения
+func startTaskSpan(ctx context.Context, task Task) (context.Context, trace.Span) {
+	return tracer().Start(task.traceContext(ctx), "process task", trace.WithAttributes(
+		attribute.String("task.id", task.ID),
+		attribute.String("task.operation", task.Operation),
+	))
+}
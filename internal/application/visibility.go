@@ -0,0 +1,67 @@
+package application
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Доставка задач внешним агентам «не менее одного раза»: выданная через
+// GET /internal/task задача считается занятой до истечения VisibilityTimeout.
+// Если результат за это время не пришёл (агент упал или потерял связь),
+// задача возвращается в очередь. Результат, пришедший после повторной выдачи,
+// всё равно принимается; второй результат той же задачи получит 404
+
+// lease – отметка, что задача выдана внешнему агенту до deadline
+func (g *taskGraph) lease(taskID string, deadline time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if node, found := g.nodes[taskID]; found {
+		node.leasedUntil = deadline
+	}
+}
+
+// expiredLeases – задачи, результат которых не пришёл до now; отметка
+// о выдаче с них снимается
+func (g *taskGraph) expiredLeases(now time.Time) []Task {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var expired []Task
+	for _, node := range g.nodes {
+		if node.leasedUntil.IsZero() || now.Before(node.leasedUntil) {
+			continue
+		}
+		node.leasedUntil = time.Time{}
+		node.taken = false
+		expired = append(expired, node.task)
+	}
+	return expired
+}
+
+// requeueExpiredTasks – возврат в очередь задач с истёкшим VisibilityTimeout
+func (a *Application) requeueExpiredTasks() {
+	for _, task := range a.graph.expiredLeases(time.Now()) {
+		slog.Warn("результат задачи не получен вовремя, задача возвращена в очередь", "task_id", task.ID, "operation", task.Operation)
+		a.enqueue(task)
+	}
+}
+
+// watchLeases – фоновая проверка просроченных задач до отмены контекста
+func (a *Application) watchLeases(ctx context.Context) {
+	ticker := time.NewTicker(leaseCheckInterval(a.config.VisibilityTimeout))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.requeueExpiredTasks()
+		}
+	}
+}
+
+// leaseCheckInterval – период проверки: задача возвращается в очередь
+// не позже чем через четверть таймаута после его истечения, но не реже раза в секунду
+func leaseCheckInterval(timeout time.Duration) time.Duration {
+	return min(timeout/4, time.Second)
+}
@@ -0,0 +1,31 @@
+// Package logging – настройка структурированного логирования сервисов
+package logging
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// ParseLevel – уровень логирования по имени (debug, info, warn, error);
+// пустая строка – info
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if strings.TrimSpace(name) == "" {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(strings.TrimSpace(name)))
+	return level, err
+}
+
+// Setup – JSON-логгер в stderr по умолчанию для slog и стандартного log.
+// Уровень берётся из LOG_LEVEL, при некорректном значении – info
+func Setup() *slog.Logger {
+	level, err := ParseLevel(os.Getenv("LOG_LEVEL"))
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
+	if err != nil {
+		logger.Warn("некорректный LOG_LEVEL, используется info", "value", os.Getenv("LOG_LEVEL"))
+	}
+	return logger
+}
@@ -0,0 +1,31 @@
+package logging_test
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/internal/logging"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level slog.Level
+	}{
+		{"", slog.LevelInfo},
+		{"debug", slog.LevelDebug},
+		{"INFO", slog.LevelInfo},
+		{"warn", slog.LevelWarn},
+		{" error ", slog.LevelError},
+	}
+	for _, test := range tests {
+		level, err := logging.ParseLevel(test.name)
+		if err != nil || level != test.level {
+			t.Fatalf("%q: expected %v, got %v (%v)", test.name, test.level, level, err)
+		}
+	}
+
+	if _, err := logging.ParseLevel("verbose"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
@@ -0,0 +1,4 @@
+// Package taskpb – сгенерированный код gRPC-протокола оркестратор–агент
+package taskpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative task.proto
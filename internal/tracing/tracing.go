@@ -0,0 +1,50 @@
+// Package tracing – настройка трассировки OpenTelemetry сервисов
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Enabled – задан адрес OTLP-коллектора, и спаны есть куда отправлять
+func Enabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup – глобальные провайдер трассировки и пропагатор W3C Trace Context.
+// Контекст трассировки передаётся в заголовке traceparent всегда, а спаны
+// экспортируются по OTLP/HTTP, только если задан OTEL_EXPORTER_OTLP_ENDPOINT
+// (или OTEL_EXPORTER_OTLP_TRACES_ENDPOINT). service – имя сервиса в спанах,
+// его переопределяет OTEL_SERVICE_NAME. Возвращает функцию, которая при
+// остановке досылает накопленные спаны
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(service)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
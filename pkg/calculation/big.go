@@ -0,0 +1,68 @@
+package calculation
+
+import "math/big"
+
+// CalcBig – вычисление выражения на big.Float с мантиссой prec бит. Числа
+// разбираются из исходной записи, поэтому 0.1 не теряет точность на пути
+// через float64. Поддерживаются + - * /, сравнения, унарный знак и скобки
+func CalcBig(expression string, prec uint) (*big.Float, error) {
+	tree, err := Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	return tree.evalBig(prec)
+}
+
+// evalBig – значение поддерева на big.Float
+func (n *Node) evalBig(prec uint) (*big.Float, error) {
+	switch n.Kind {
+	case NumberNode:
+		if n.Text == "" {
+			// Константы известны только с точностью float64
+			return new(big.Float).SetPrec(prec).SetFloat64(n.Value), nil
+		}
+		value, _, err := big.ParseFloat(n.Text, 10, prec, big.ToNearestEven)
+		if err != nil {
+			return nil, ErrInvalidExpression
+		}
+		return value, nil
+	case UnaryNode:
+		v, err := n.Left.evalBig(prec)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op == "-" {
+			v.Neg(v)
+		}
+		return v, nil
+	case FuncNode:
+		return nil, ErrUnknownFunction
+	}
+
+	a, err := n.Left.evalBig(prec)
+	if err != nil {
+		return nil, err
+	}
+	b, err := n.Right.evalBig(prec)
+	if err != nil {
+		return nil, err
+	}
+	result := new(big.Float).SetPrec(prec)
+	switch n.Op {
+	case "+":
+		return result.Add(a, b), nil
+	case "-":
+		return result.Sub(a, b), nil
+	case "*":
+		return result.Mul(a, b), nil
+	case "/":
+		if b.Sign() == 0 {
+			return nil, ErrDivisionByZero
+		}
+		return result.Quo(a, b), nil
+	case "<", ">", "<=", ">=", "==", "!=":
+		// Знак разности a - b задаёт исход любого сравнения
+		return result.SetFloat64(compare(n.Op, float64(a.Cmp(b)), 0)), nil
+	}
+	return nil, ErrUnsupportedOperator
+}
@@ -1,121 +1,197 @@
 package calculation
 
-import (
-	"strconv"
-	"strings"
+import "math"
+
+// NodeKind – вид узла дерева выражения
+type NodeKind int
+
+const (
+	NumberNode NodeKind = iota
+	BinaryNode
+	UnaryNode
+	FuncNode
 )
 
+// Node – узел дерева выражения: число, бинарная операция над двумя поддеревьями,
+// унарный знак или вызов функции Op над единственным операндом Left.
+// Text – запись числа во входной строке (пусто для констант)
+type Node struct {
+	Kind  NodeKind
+	Op    string
+	Value float64
+	Text  string
+	Left  *Node
+	Right *Node
+}
+
 func Calc(expression string) (float64, error) {
-	expression = strings.ReplaceAll(expression, " ", "")
-	result, err := evaluateexpression(expression)
+	tree, err := Parse(expression)
 	if err != nil {
 		return 0, err
 	}
-	return result, nil
+	return tree.Eval()
 }
 
-func searchnumbers(expression string, index int) (float64, int) {
-	start := index
-	for index < len(expression) && (isDigit(expression[index]) || expression[index] == '.') {
-		index++
+// Eval – вычисляет значение поддерева
+func (n *Node) Eval() (float64, error) {
+	switch n.Kind {
+	case NumberNode:
+		return n.Value, nil
+	case UnaryNode:
+		v, err := n.Left.Eval()
+		if err != nil {
+			return 0, err
+		}
+		if n.Op == "-" {
+			return -v, nil
+		}
+		return v, nil
+	case FuncNode:
+		v, err := n.Left.Eval()
+		if err != nil {
+			return 0, err
+		}
+		return ApplyFunc(n.Op, v)
 	}
-	val, _ := strconv.ParseFloat(expression[start:index], 64)
-	return val, index
-}
-
-func precedence(op rune) int {
-	switch op {
-	case '+', '-':
-		return 1
-	case '*', '/':
-		return 2
+	a, err := n.Left.Eval()
+	if err != nil {
+		return 0, err
 	}
-	return 0
+	b, err := n.Right.Eval()
+	if err != nil {
+		return 0, err
+	}
+	return Apply(n.Op, a, b)
 }
 
-func isDigit(char byte) bool {
-	return char >= '0' && char <= '9'
+// Apply – применяет бинарный оператор к двум аргументам. Если из конечных
+// аргументов получилась бесконечность, результат вышел за пределы float64
+// и возвращается ErrOverflow
+func Apply(op string, a, b float64) (float64, error) {
+	result, err := apply(op, a, b)
+	if err != nil {
+		return 0, err
+	}
+	if Overflowed(result) && !Overflowed(a) && !Overflowed(b) {
+		return 0, ErrOverflow
+	}
+	return result, nil
 }
 
-func isOperator(char byte) bool {
-	return char == '+' || char == '-' || char == '*' || char == '/'
+// Overflowed – значение вышло за пределы float64
+func Overflowed(x float64) bool {
+	return math.IsInf(x, 0)
 }
 
-func evaluateexpression(expression string) (float64, error) {
-	var ops []rune
-	var values []float64
-	for i := 0; i < len(expression); i++ {
-		char := expression[i]
-		if isDigit(char) {
-			val, nextindex := searchnumbers(expression, i)
-			values = append(values, val)
-			i = nextindex - 1
-		} else if char == '(' {
-			ops = append(ops, '(')
-		} else if char == ')' {
-			for len(ops) > 0 && ops[len(ops)-1] != '(' {
-				var err error
-				values, err = attachOperator(ops[len(ops)-1], values)
-				if err != nil {
-					return 0, ErrInvalidExpression
-				}
-				ops = ops[:len(ops)-1]
-			}
-			if len(ops) == 0 {
-				return 0, ErrInvalidParentheses
-			}
-			ops = ops[:len(ops)-1]
-		} else if isOperator(char) {
-			for len(ops) > 0 && precedence(rune(char)) <= precedence(ops[len(ops)-1]) {
-				var err error
-				values, err = attachOperator(ops[len(ops)-1], values)
-				if err != nil {
-					return 0, err
-				}
-				ops = ops[:len(ops)-1]
-			}
-			ops = append(ops, rune(char))
-		} else {
-			return 0, ErrInvalidCalculation
+func apply(op string, a, b float64) (float64, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, ErrDivisionByZero
 		}
-	}
-	for len(ops) > 0 {
-		var err error
-		values, err = attachOperator(ops[len(ops)-1], values)
-		if err != nil {
-			return 0, err
+		return a / b, nil
+	case "%":
+		if a != math.Trunc(a) || b != math.Trunc(b) {
+			return 0, ErrNonIntegerOperand
 		}
-		ops = ops[:len(ops)-1]
-	}
-	if len(values) != 1 {
-		return 0, ErrInvalidValuesCount
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return math.Mod(a, b), nil
+	case "//":
+		// Частное отбрасывает дробную часть, как и остаток "%" берёт знак
+		// делимого: a == b * (a // b) + a % b
+		if a != math.Trunc(a) || b != math.Trunc(b) {
+			return 0, ErrNonIntegerOperand
+		}
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return math.Trunc(a / b), nil
+	case "^":
+		if a == 0 && b == 0 {
+			return 0, ErrUndefinedPower
+		}
+		// 0 в отрицательной степени – это деление на ноль, а не +Inf
+		if a == 0 && b < 0 {
+			return 0, ErrDivisionByZero
+		}
+		if a < 0 && b != math.Trunc(b) {
+			return 0, ErrUndefinedPower
+		}
+		return math.Pow(a, b), nil
+	case "<", ">", "<=", ">=", "==", "!=":
+		return compare(op, a, b), nil
 	}
-	return values[0], nil
+	return 0, ErrUnsupportedOperator
 }
 
-func attachOperator(op rune, values []float64) ([]float64, error) {
-	if len(values) < 2 {
-		return values, ErrInvalidValuesCount
-	}
-	a := values[len(values)-1]
-	b := values[len(values)-2]
-	values = values[:len(values)-2]
-	var result float64
+// compare – сравнение a и b: 1, если оно верно, иначе 0. Дробные числа
+// сравниваются точно, без допуска, поэтому 0.1 + 0.2 == 0.3 даёт 0: сумма
+// в float64 равна 0.30000000000000004. Для сравнения с допуском используйте
+// abs(a - b) < 1e-9
+func compare(op string, a, b float64) float64 {
+	var ok bool
 	switch op {
-	case '+':
-		result = b + a
-	case '-':
-		result = b - a
-	case '*':
-		result = b * a
-	case '/':
-		if a == 0 {
-			return values, ErrInvalidZero
+	case "<":
+		ok = a < b
+	case ">":
+		ok = a > b
+	case "<=":
+		ok = a <= b
+	case ">=":
+		ok = a >= b
+	case "==":
+		ok = a == b
+	case "!=":
+		ok = a != b
+	}
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// ApplyFunc – вычисляет встроенную функцию одной переменной
+func ApplyFunc(name string, x float64) (float64, error) {
+	switch name {
+	case "sqrt":
+		if x < 0 {
+			return 0, ErrInvalidFunctionArgument
+		}
+		return math.Sqrt(x), nil
+	case "abs":
+		return math.Abs(x), nil
+	case "sin":
+		return math.Sin(x), nil
+	case "cos":
+		return math.Cos(x), nil
+	case "tan":
+		return math.Tan(x), nil
+	case "ln":
+		if x <= 0 {
+			return 0, ErrInvalidFunctionArgument
+		}
+		return math.Log(x), nil
+	case "log":
+		if x <= 0 {
+			return 0, ErrInvalidFunctionArgument
 		}
-		result = b / a
-	default:
-		return values, ErrInvalidOperand
+		return math.Log10(x), nil
+	}
+	return 0, ErrUnknownFunction
+}
+
+func isFunction(name string) bool {
+	switch name {
+	case "sqrt", "abs", "sin", "cos", "tan", "ln", "log":
+		return true
 	}
-	values = append(values, result)
-	return values, nil
+	return false
 }
@@ -1,6 +1,9 @@
 package calculation_test
 
 import (
+	"errors"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/calculation"
@@ -32,6 +35,251 @@ func TestCalc(t *testing.T) {
 			expression:     "1/2",
 			expectedResult: 0.5,
 		},
+		{
+			name:           "spaces",
+			expression:     "2 + 2 * 2",
+			expectedResult: 6,
+		},
+		{
+			name:           "parentheses",
+			expression:     "(3 + 4) / 2",
+			expectedResult: 3.5,
+		},
+		{
+			name:           "nested parentheses",
+			expression:     "((1 + 2) * (3 - 1)) / (2 * (1 + 2))",
+			expectedResult: 1,
+		},
+		{
+			name:           "left associativity",
+			expression:     "8 - 3 - 2",
+			expectedResult: 3,
+		},
+		{
+			name:           "leading unary minus",
+			expression:     "-5 + 3",
+			expectedResult: -2,
+		},
+		{
+			name:           "unary minus after operator",
+			expression:     "3 * -2",
+			expectedResult: -6,
+		},
+		{
+			name:           "unary minus after parenthesis",
+			expression:     "(-2 + 5) * 2",
+			expectedResult: 6,
+		},
+		{
+			name:           "double unary minus",
+			expression:     "--5",
+			expectedResult: 5,
+		},
+		{
+			name:           "unary plus",
+			expression:     "+4 - +1",
+			expectedResult: 3,
+		},
+		{
+			name:           "unary minus before parentheses",
+			expression:     "-(2 + 3)",
+			expectedResult: -5,
+		},
+		{
+			name:           "power",
+			expression:     "2 ^ 10",
+			expectedResult: 1024,
+		},
+		{
+			name:           "power right associativity",
+			expression:     "2 ^ 3 ^ 2",
+			expectedResult: 512,
+		},
+		{
+			name:           "power priority",
+			expression:     "2 * 3 ^ 2",
+			expectedResult: 18,
+		},
+		{
+			name:           "unary minus and power",
+			expression:     "-2 ^ 2",
+			expectedResult: -4,
+		},
+		{
+			name:           "negative exponent",
+			expression:     "2 ^ -1",
+			expectedResult: 0.5,
+		},
+		{
+			name:           "negative base integer exponent",
+			expression:     "(-2) ^ 3",
+			expectedResult: -8,
+		},
+		{
+			name:           "remainder",
+			expression:     "10 % 3",
+			expectedResult: 1,
+		},
+		{
+			name:           "remainder priority",
+			expression:     "1 + 10 % 4 * 2",
+			expectedResult: 5,
+		},
+		{
+			name:           "remainder of negative",
+			expression:     "-7 % 3",
+			expectedResult: -1,
+		},
+		{
+			name:           "largest float64",
+			expression:     "1.7976931348623157e308 * 1",
+			expectedResult: math.MaxFloat64,
+		},
+		{
+			name:           "largest power of two",
+			expression:     "2 ^ 1023",
+			expectedResult: math.Pow(2, 1023),
+		},
+		{
+			name:           "underflow to zero",
+			expression:     "1e-308 * 1e-100",
+			expectedResult: 0,
+		},
+		{
+			name:           "integer division",
+			expression:     "7 // 2",
+			expectedResult: 3,
+		},
+		{
+			name:           "integer division of negative",
+			expression:     "-7 // 2",
+			expectedResult: -3,
+		},
+		{
+			name:           "integer division by negative",
+			expression:     "7 // -2",
+			expectedResult: -3,
+		},
+		{
+			name:           "integer division of negatives",
+			expression:     "-7 // -2",
+			expectedResult: 3,
+		},
+		{
+			name:           "integer division priority",
+			expression:     "1 + 9 // 2 * 3",
+			expectedResult: 13,
+		},
+		{
+			name:           "integer division and remainder",
+			expression:     "2 * (-7 // 2) + -7 % 2",
+			expectedResult: -7,
+		},
+		{
+			name:           "functions",
+			expression:     "sqrt(16) + abs(-3)",
+			expectedResult: 7,
+		},
+		{
+			name:           "function of expression",
+			expression:     "sqrt(3 * 3 + 4 * 4) * 2",
+			expectedResult: 10,
+		},
+		{
+			name:           "nested functions",
+			expression:     "abs(sin(0) - cos(0))",
+			expectedResult: 1,
+		},
+		{
+			name:           "logarithms",
+			expression:     "log(1000) + ln(1)",
+			expectedResult: 3,
+		},
+		{
+			name:           "negated function",
+			expression:     "-sqrt(4)",
+			expectedResult: -2,
+		},
+		{
+			name:           "pi",
+			expression:     "pi * 2",
+			expectedResult: math.Pi * 2,
+		},
+		{
+			name:           "constants ignore case",
+			expression:     "PI - Pi",
+			expectedResult: 0,
+		},
+		{
+			name:           "e",
+			expression:     "ln(e)",
+			expectedResult: 1,
+		},
+		{
+			name:           "functions ignore case",
+			expression:     "SQRT(9)",
+			expectedResult: 3,
+		},
+		{
+			name:           "scientific notation",
+			expression:     "1e3 + 1",
+			expectedResult: 1001,
+		},
+		{
+			name:           "negative exponent",
+			expression:     "2.5e-1 * 4",
+			expectedResult: 1,
+		},
+		{
+			name:           "exponent without spaces",
+			expression:     "2E10/1e+10-1.5e-3",
+			expectedResult: 2 - 0.0015,
+		},
+		{
+			name:           "number times e",
+			expression:     "2*e",
+			expectedResult: 2 * math.E,
+		},
+		{
+			name:           "greater than",
+			expression:     "3 > 2",
+			expectedResult: 1,
+		},
+		{
+			name:           "equal",
+			expression:     "5 == 5",
+			expectedResult: 1,
+		},
+		{
+			name:           "false comparison",
+			expression:     "2 >= 3",
+			expectedResult: 0,
+		},
+		{
+			name:           "not equal without spaces",
+			expression:     "2!=3",
+			expectedResult: 1,
+		},
+		{
+			name:           "comparison binds loosest",
+			expression:     "1 + 1 <= 4 / 2",
+			expectedResult: 1,
+		},
+		{
+			name:           "chained comparison",
+			expression:     "3 > 2 > 1",
+			expectedResult: 0,
+		},
+		{
+			name:           "fractions compared exactly",
+			expression:     "0.1 + 0.2 == 0.3",
+			expectedResult: 0,
+		},
+		{
+			name:           "comparison with tolerance",
+			expression:     "abs(0.1 + 0.2 - 0.3) < 1e-9",
+			expectedResult: 1,
+		},
 	}
 
 	for _, testCase := range testCasesSuccess {
@@ -67,6 +315,140 @@ func TestCalc(t *testing.T) {
 			name:       "/",
 			expression: "",
 		},
+		{
+			name:       "dangling unary minus",
+			expression: "5 * -",
+		},
+		{
+			name:        "unclosed parenthesis",
+			expression:  "(3 + 4",
+			expectedErr: calculation.ErrUnbalancedParens,
+		},
+		{
+			name:        "unopened parenthesis",
+			expression:  "3 + 4)",
+			expectedErr: calculation.ErrUnbalancedParens,
+		},
+		{
+			name:        "zero to the zero power",
+			expression:  "0 ^ 0",
+			expectedErr: calculation.ErrUndefinedPower,
+		},
+		{
+			name:        "zero to a negative power",
+			expression:  "0 ^ -1",
+			expectedErr: calculation.ErrDivisionByZero,
+		},
+		{
+			name:        "negative base fractional exponent",
+			expression:  "(-8) ^ 0.5",
+			expectedErr: calculation.ErrUndefinedPower,
+		},
+		{
+			name:        "remainder of fraction",
+			expression:  "10.5 % 3",
+			expectedErr: calculation.ErrNonIntegerOperand,
+		},
+		{
+			name:        "remainder by fraction",
+			expression:  "10 % 2.5",
+			expectedErr: calculation.ErrNonIntegerOperand,
+		},
+		{
+			name:        "remainder by zero",
+			expression:  "10 % 0",
+			expectedErr: calculation.ErrDivisionByZero,
+		},
+		{
+			name:        "multiplication overflow",
+			expression:  "1e308 * 10",
+			expectedErr: calculation.ErrOverflow,
+		},
+		{
+			name:        "negative overflow",
+			expression:  "-1.7976931348623157e308 * 2",
+			expectedErr: calculation.ErrOverflow,
+		},
+		{
+			name:        "addition overflow",
+			expression:  "1.7976931348623157e308 + 1.7976931348623157e308",
+			expectedErr: calculation.ErrOverflow,
+		},
+		{
+			name:        "power overflow",
+			expression:  "2 ^ 1024",
+			expectedErr: calculation.ErrOverflow,
+		},
+		{
+			name:        "division overflow",
+			expression:  "1e308 / 1e-10",
+			expectedErr: calculation.ErrOverflow,
+		},
+		{
+			name:        "integer division of fraction",
+			expression:  "7.5 // 2",
+			expectedErr: calculation.ErrNonIntegerOperand,
+		},
+		{
+			name:        "integer division by zero",
+			expression:  "7 // 0",
+			expectedErr: calculation.ErrDivisionByZero,
+		},
+		{
+			name:        "integer division without divisor",
+			expression:  "7 // / 2",
+			expectedErr: calculation.ErrInvalidExpression,
+		},
+		{
+			name:        "sqrt of negative",
+			expression:  "sqrt(-4)",
+			expectedErr: calculation.ErrInvalidFunctionArgument,
+		},
+		{
+			name:        "ln of zero",
+			expression:  "ln(0)",
+			expectedErr: calculation.ErrInvalidFunctionArgument,
+		},
+		{
+			name:        "unknown function",
+			expression:  "foo(1)",
+			expectedErr: calculation.ErrUnknownFunction,
+		},
+		{
+			name:        "unexpected character",
+			expression:  "2 & 3",
+			expectedErr: calculation.ErrInvalidExpression,
+		},
+		{
+			name:        "single equals sign",
+			expression:  "2 = 2",
+			expectedErr: calculation.ErrInvalidExpression,
+		},
+		{
+			name:        "negation sign",
+			expression:  "!2",
+			expectedErr: calculation.ErrInvalidExpression,
+		},
+		{
+			name:        "comparison without operand",
+			expression:  "2 <",
+			expectedErr: calculation.ErrInvalidExpression,
+		},
+		{
+			name:        "unknown identifier",
+			expression:  "tau * 2",
+			expectedErr: calculation.ErrInvalidExpression,
+		},
+		{
+			name:        "function without parentheses",
+			expression:  "sqrt 4",
+			expectedErr: calculation.ErrInvalidExpression,
+		},
+		{
+			name:        "division by zero",
+			expression:  "1 / (2 - 2)",
+			expectedErr: calculation.ErrDivisionByZero,
+		},
 	}
 
 	for _, testCase := range testCasesFail {
@@ -75,6 +457,262 @@ func TestCalc(t *testing.T) {
 			if err == nil {
 				t.Fatalf("expression %s is invalid but result  %f was obtained", testCase.expression, val)
 			}
+			if testCase.expectedErr != nil && !errors.Is(err, testCase.expectedErr) {
+				t.Fatalf("expression %s: expected error %v, got %v", testCase.expression, testCase.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	// Пробелы между лексемами необязательны и не влияют на разбиение
+	expected := []string{"2", "+", "2", "*", "(", "-", "3.5", "//", "sqrt", "(", "4", ")", ")"}
+	for _, expression := range []string{
+		"2+2*(-3.5//sqrt(4))",
+		"2 + 2 * ( - 3.5 // sqrt ( 4 ) )",
+		" 2\t+2 *\n(-3.5 //sqrt(4)) ",
+	} {
+		tokens, err := calculation.Tokenize(expression)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", expression, err)
+		}
+		texts := make([]string, len(tokens))
+		for i, token := range tokens {
+			texts[i] = token.Text
+		}
+		if strings.Join(texts, " ") != strings.Join(expected, " ") {
+			t.Errorf("%q: expected tokens %q, got %q", expression, expected, texts)
+		}
+	}
+
+	if result, err := calculation.Calc("2+2*2"); err != nil || result != 6 {
+		t.Fatalf("expected 6, got %v, %v", result, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tree, err := calculation.Parse("2 + 2 * 2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tree.Kind != calculation.BinaryNode || tree.Op != "+" {
+		t.Fatalf("root should be \"+\", got %q", tree.Op)
+	}
+	if tree.Right.Kind != calculation.BinaryNode || tree.Right.Op != "*" {
+		t.Fatalf("right subtree should be \"*\", got %q", tree.Right.Op)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	testCases := []struct {
+		expression string
+		pos        int
+		message    string
+	}{
+		{expression: "2 + 3 3", pos: 6, message: `invalid expression: expected operator, got "3" at position 7`},
+		{expression: "2 + * 3", pos: 4, message: `invalid expression: expected operand, got "*" at position 5`},
+		{expression: "2 +", pos: 3, message: "invalid expression: expected operand at position 4"},
+		{expression: "2 & 3", pos: 2, message: `invalid expression: unexpected character '&' at position 3`},
+		{expression: "(1 + 2", pos: 6, message: `unbalanced parentheses: missing ")" at position 7`},
+		{expression: "1 + 2)", pos: 5, message: `unbalanced parentheses: unexpected ")" at position 6`},
+		{expression: "1 + foo(2)", pos: 4, message: `unknown function: "foo" at position 5`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.expression, func(t *testing.T) {
+			_, err := calculation.Parse(testCase.expression)
+			var parseErr *calculation.ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("expected *ParseError, got %v", err)
+			}
+			if parseErr.Pos != testCase.pos {
+				t.Errorf("expected position %d, got %d", testCase.pos, parseErr.Pos)
+			}
+			if err.Error() != testCase.message {
+				t.Errorf("expected message %q, got %q", testCase.message, err.Error())
+			}
 		})
 	}
 }
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		expressions []string
+		normalized  string
+	}{
+		{[]string{"2+2", " 2 + 2 ", "(2 + 2)", "((2)) + (2)", "2.0 + 2", "2 +2"}, "2 + 2"},
+		{[]string{"2 + 2 * 2", "2 + (2 * 2)"}, "2 + 2 * 2"},
+		{[]string{"(2 + 2) * 2"}, "(2 + 2) * 2"},
+		{[]string{"1 - 2 - 3", "(1 - 2) - 3"}, "1 - 2 - 3"},
+		{[]string{"1 - (2 - 3)"}, "1 - (2 - 3)"},
+		{[]string{"2 ^ 3 ^ 2", "2 ^ (3 ^ 2)"}, "2 ^ 3 ^ 2"},
+		{[]string{"(2 ^ 3) ^ 2"}, "(2 ^ 3) ^ 2"},
+		{[]string{"-2 ^ 2", "-(2 ^ 2)"}, "-2 ^ 2"},
+		{[]string{"(-2) ^ 2"}, "(-2) ^ 2"},
+		{[]string{"-(1 + 2)"}, "-(1 + 2)"},
+		{[]string{"3 * -2", "3 * (-2)"}, "3 * -2"},
+		{[]string{"SQRT(16)", "sqrt( 16 )"}, "sqrt(16)"},
+		{[]string{"1e3 // 7", "1000 // 7"}, "1000 // 7"},
+		{[]string{"1+1==2", "(1 + 1) == (2)"}, "1 + 1 == 2"},
+		{[]string{"(1 < 2) < 3"}, "1 < 2 < 3"},
+		{[]string{"1 < (2 < 3)"}, "1 < (2 < 3)"},
+		{[]string{"-2 * 3", "(-2) * 3"}, "-2 * 3"},
+		{[]string{"-(2 * 3)"}, "-(2 * 3)"},
+	}
+
+	for _, testCase := range testCases {
+		for _, expression := range testCase.expressions {
+			normalized, err := calculation.Normalize(expression)
+			if err != nil {
+				t.Fatalf("%q: unexpected error: %v", expression, err)
+			}
+			if normalized != testCase.normalized {
+				t.Errorf("%q: expected %q, got %q", expression, testCase.normalized, normalized)
+			}
+			// Каноническая запись не меняется при повторной нормализации
+			if again, _ := calculation.Normalize(normalized); again != normalized {
+				t.Errorf("%q: normalization is not idempotent: %q", normalized, again)
+			}
+		}
+	}
+
+	if _, err := calculation.Normalize("2 +"); !errors.Is(err, calculation.ErrInvalidExpression) {
+		t.Fatalf("expected ErrInvalidExpression, got %v", err)
+	}
+}
+
+func TestParseDepth(t *testing.T) {
+	nested := func(n int) string {
+		return strings.Repeat("(", n) + "1" + strings.Repeat(")", n)
+	}
+
+	if _, err := calculation.Parse(nested(calculation.DefaultMaxDepth)); err != nil {
+		t.Fatalf("expected %d nested parentheses to be accepted, got %v", calculation.DefaultMaxDepth, err)
+	}
+	if _, err := calculation.Parse(nested(calculation.DefaultMaxDepth + 1)); !errors.Is(err, calculation.ErrTooDeep) {
+		t.Fatalf("expected ErrTooDeep, got %v", err)
+	}
+	// Глубина, заведомо достаточная для переполнения стека без предела
+	if _, err := calculation.Parse(nested(1_000_000)); !errors.Is(err, calculation.ErrTooDeep) {
+		t.Fatalf("expected ErrTooDeep for deep input, got %v", err)
+	}
+	if _, err := calculation.Parse(strings.Repeat("-", 1000) + "1"); !errors.Is(err, calculation.ErrTooDeep) {
+		t.Fatalf("expected ErrTooDeep for long unary chain, got %v", err)
+	}
+	if _, err := calculation.ParseDepth(nested(5), 3); !errors.Is(err, calculation.ErrTooDeep) {
+		t.Fatalf("expected ErrTooDeep with custom limit, got %v", err)
+	}
+	// Длинная цепочка левоассоциативных операций вложенностью не считается
+	if _, err := calculation.Parse(strings.Repeat("1 + ", 1000) + "1"); err != nil {
+		t.Fatalf("unexpected error for long flat expression: %v", err)
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	vars := map[string]float64{"x": 3, "y": 4, "e": 10, "rate_2": 0.5}
+
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"x * 2 + y", 10},
+		{"-x ^ 2", -9},
+		{"sqrt(x * x + y * y)", 5},
+		// Переменная перекрывает одноимённую константу, регистр имени важен
+		{"e + E", 10 + math.E},
+		{"rate_2 * y", 2},
+		{"pi", math.Pi},
+	}
+	for _, test := range tests {
+		tree, err := calculation.ParseVars(test.expression, vars, calculation.DefaultMaxDepth)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.expression, err)
+		}
+		if got, _ := tree.Eval(); got != test.expected {
+			t.Fatalf("%s: expected %v, got %v", test.expression, test.expected, got)
+		}
+	}
+
+	_, err := calculation.ParseVars("x * z", vars, calculation.DefaultMaxDepth)
+	var parseErr *calculation.ParseError
+	if !errors.As(err, &parseErr) || !errors.Is(err, calculation.ErrInvalidExpression) {
+		t.Fatalf("expected parse error for undefined variable, got %v", err)
+	}
+	if parseErr.Pos != 4 || parseErr.Token != "z" || !strings.Contains(err.Error(), `undefined variable "z"`) {
+		t.Fatalf("expected undefined variable z at 4, got %v", err)
+	}
+	if _, err := calculation.Parse("x * 2"); !errors.Is(err, calculation.ErrInvalidExpression) {
+		t.Fatalf("expected error for variable without value, got %v", err)
+	}
+
+	normalized, err := calculation.NormalizeVars("x*2 + (y)", vars)
+	if err != nil || normalized != "3 * 2 + 4" {
+		t.Fatalf("expected substituted values, got %q, %v", normalized, err)
+	}
+	// Отрицательная переменная записывается так, чтобы запись разбиралась в то же дерево
+	negative := map[string]float64{"x": -3}
+	negatives := []struct {
+		expression string
+		normalized string
+	}{
+		{"x ^ 2", "(-3) ^ 2"},
+		{"x + 1", "-3 + 1"},
+		{"2 - x", "2 - -3"},
+		{"-x", "--3"},
+		{"2 ^ x", "2 ^ -3"},
+	}
+	for _, test := range negatives {
+		normalized, err := calculation.NormalizeVars(test.expression, negative)
+		if err != nil || normalized != test.normalized {
+			t.Fatalf("%s: expected %q, got %q, %v", test.expression, test.normalized, normalized, err)
+		}
+		withVars, _ := calculation.ParseVars(test.expression, negative, calculation.DefaultMaxDepth)
+		reparsed, _ := calculation.Parse(normalized)
+		want, _ := withVars.Eval()
+		if got, _ := reparsed.Eval(); got != want {
+			t.Fatalf("%s: normalized %q evaluates to %v, expected %v", test.expression, normalized, got, want)
+		}
+	}
+	literal, _ := calculation.Normalize("-3 ^ 2")
+	if squared, _ := calculation.NormalizeVars("x ^ 2", negative); squared == literal {
+		t.Fatalf("x ^ 2 with x = -3 must differ from -3 ^ 2, both are %q", literal)
+	}
+}
+
+func TestCalcBig(t *testing.T) {
+	sum, err := calculation.CalcBig("0.1 + 0.2", 200)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := sum.Text('f', 30); got != "0.300000000000000000000000000000" {
+		t.Fatalf("0.1 + 0.2 should be 0.3, got %s", got)
+	}
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"(1 + 2) * -3", "-9"},
+		{"1 / 3 * 3", "1"},
+		{"123456789012345678901234567890 + 1", "123456789012345678901234567891"},
+		// Без потери точности на float64 равенство выполняется
+		{"0.1 + 0.2 == 0.3", "1"},
+		{"1 / 3 > 0.3333", "1"},
+	}
+	for _, test := range tests {
+		value, err := calculation.CalcBig(test.expression, 256)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.expression, err)
+		}
+		if got := value.Text('f', 0); got != test.expected {
+			t.Fatalf("%s: expected %s, got %s", test.expression, test.expected, got)
+		}
+	}
+
+	if _, err := calculation.CalcBig("1 / (2 - 2)", 64); !errors.Is(err, calculation.ErrDivisionByZero) {
+		t.Fatalf("expected division by zero, got %v", err)
+	}
+	if _, err := calculation.CalcBig("2 ^ 3", 64); !errors.Is(err, calculation.ErrUnsupportedOperator) {
+		t.Fatalf("expected unsupported operator, got %v", err)
+	}
+}
@@ -1,12 +1,60 @@
 package calculation
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidExpression       = errors.New("invalid expression")
+	ErrUnbalancedParens        = errors.New("unbalanced parentheses")
+	ErrDivisionByZero          = errors.New("division by zero")
+	ErrUnsupportedOperator     = errors.New("unsupported operator")
+	ErrInvalidValuesCount      = errors.New("invalid number of values")
+	ErrInvalidCalculation      = errors.New("invalid calculation")
+	ErrUndefinedPower          = errors.New("undefined power")
+	ErrNonIntegerOperand       = errors.New("operands must be integers")
+	ErrUnknownFunction         = errors.New("unknown function")
+	ErrInvalidFunctionArgument = errors.New("function argument out of domain")
+	ErrOverflow                = errors.New("overflow")
+	ErrTooDeep                 = errors.New("expression is nested too deeply")
+)
 
+// Прежние имена ошибок, оставлены для совместимости
 var (
-	ErrInvalidExpression  = errors.New("invalid expression")
-	ErrInvalidParentheses = errors.New("invalid parentheses")
-	ErrInvalidZero        = errors.New("division by zero")
-	ErrInvalidOperand     = errors.New("unknown operand")
-	ErrInvalidValuesCount = errors.New("invalid number of values")
-	ErrInvalidCalculation = errors.New("invalid calculation")
+	// Deprecated: используйте ErrUnbalancedParens
+	ErrInvalidParentheses = ErrUnbalancedParens
+	// Deprecated: используйте ErrDivisionByZero
+	ErrInvalidZero = ErrDivisionByZero
+	// Deprecated: используйте ErrUnsupportedOperator
+	ErrInvalidOperand = ErrUnsupportedOperator
 )
+
+// ParseError – ошибка разбора с местом, где она найдена. Pos – байтовое
+// смещение лексемы во входной строке (для конца выражения – его длина),
+// Token – текст лексемы (пусто в конце выражения). errors.Is сравнивает
+// с причиной Err, например ErrUnbalancedParens
+type ParseError struct {
+	Err     error
+	Pos     int
+	Token   string
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Err, e.Message)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// errorAt – ошибка разбора у лексемы tok; в сообщении позиция считается с единицы
+func errorAt(err error, tok Token, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Err:     err,
+		Pos:     tok.Pos,
+		Token:   tok.Text,
+		Message: fmt.Sprintf(format, args...) + fmt.Sprintf(" at position %d", tok.Pos+1),
+	}
+}
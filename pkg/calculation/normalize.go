@@ -0,0 +1,94 @@
+package calculation
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// unaryPrecedence – унарный знак связывает сильнее * и /, но слабее степени
+const unaryPrecedence = 3
+
+// Normalize – каноническая запись выражения: одинаковые по смыслу записи
+// ("2+2", " 2 + 2 ", "(2 + 2)", "2.0 + 2") дают одну строку. Пробелы
+// расставляются единообразно, лишние скобки убираются, числа и константы
+// записываются значением, имена функций – в нижнем регистре
+func Normalize(expression string) (string, error) {
+	return NormalizeVars(expression, nil)
+}
+
+// NormalizeVars – каноническая запись выражения с подставленными значениями
+// переменных vars: "x * 2" при x = 3 записывается как "3 * 2"
+func NormalizeVars(expression string, vars map[string]float64) (string, error) {
+	tree, err := ParseVars(expression, vars, DefaultMaxDepth)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	writeNode(&sb, tree)
+	return sb.String(), nil
+}
+
+// writeNode – запись поддерева со скобками только там, где без них
+// изменился бы порядок вычисления
+func writeNode(sb *strings.Builder, n *Node) {
+	if negativeNumber(n) {
+		// Отрицательное значение переменной пишется так, как его разобрал бы
+		// парсер, – унарным минусом: x ^ 2 при x = -3 даёт "(-3) ^ 2", а не
+		// "-3 ^ 2", то есть -(3 ^ 2)
+		writeNode(sb, &Node{Kind: UnaryNode, Op: "-", Left: &Node{Kind: NumberNode, Value: -n.Value}})
+		return
+	}
+	switch n.Kind {
+	case NumberNode:
+		sb.WriteString(strconv.FormatFloat(n.Value, 'g', -1, 64))
+	case UnaryNode:
+		if n.Op == "-" {
+			sb.WriteString("-")
+		}
+		// Операнд унарного знака – число, вызов, степень или снова знак
+		writeChild(sb, n.Left, n.Left.Kind == BinaryNode && nodePrecedence(n.Left) < precedence("^"))
+	case FuncNode:
+		sb.WriteString(n.Op)
+		sb.WriteString("(")
+		writeNode(sb, n.Left)
+		sb.WriteString(")")
+	case BinaryNode:
+		prec := precedence(n.Op)
+		left, right := nodePrecedence(n.Left), nodePrecedence(n.Right)
+		writeChild(sb, n.Left, left < prec || left == prec && rightAssociative(n.Op))
+		sb.WriteString(" " + n.Op + " ")
+		// Унарный знак справа скобок не требует: его операнд не длиннее степени
+		writeChild(sb, n.Right, n.Right.Kind == BinaryNode && (right < prec || right == prec && !rightAssociative(n.Op)))
+	}
+}
+
+func writeChild(sb *strings.Builder, n *Node, parens bool) {
+	if parens {
+		sb.WriteString("(")
+	}
+	writeNode(sb, n)
+	if parens {
+		sb.WriteString(")")
+	}
+}
+
+// nodePrecedence – приоритет корня поддерева; у чисел и вызовов он наибольший
+func nodePrecedence(n *Node) int {
+	if negativeNumber(n) {
+		return unaryPrecedence
+	}
+	switch n.Kind {
+	case BinaryNode:
+		return precedence(n.Op)
+	case UnaryNode:
+		return unaryPrecedence
+	}
+	return precedence("^") + 1
+}
+
+// negativeNumber – число со знаком минус (в том числе -0); такие числа
+// попадают в дерево только из переменных
+func negativeNumber(n *Node) bool {
+	return n.Kind == NumberNode && math.Signbit(n.Value)
+}
@@ -0,0 +1,213 @@
+package calculation
+
+import (
+	"math"
+	"strings"
+)
+
+// constants – именованные константы, допустимые в выражениях (регистр не важен)
+var constants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// parser – разбор последовательности лексем методом рекурсивного спуска
+// с учётом приоритета бинарных операций
+type parser struct {
+	tokens []Token
+	pos    int
+	// end – позиция конца выражения для ошибок вида «выражение оборвалось»
+	end int
+	// depth – текущая глубина вложенности, maxDepth – её предел
+	depth    int
+	maxDepth int
+	// vars – значения переменных выражения по имени
+	vars map[string]float64
+}
+
+// DefaultMaxDepth – предел глубины вложенности для Parse
+const DefaultMaxDepth = 100
+
+// Parse – строит дерево выражения по его строковой записи
+func Parse(expression string) (*Node, error) {
+	return ParseDepth(expression, DefaultMaxDepth)
+}
+
+// ParseDepth – как Parse, но с пределом глубины вложенности maxDepth.
+// Вложенностью считаются скобки, аргументы функций, операнды унарного знака
+// и правые операнды бинарных операций; более глубокое выражение отвергается
+// с ErrTooDeep, не доводя рекурсивный разбор до переполнения стека
+func ParseDepth(expression string, maxDepth int) (*Node, error) {
+	return ParseVars(expression, nil, maxDepth)
+}
+
+// ParseVars – как ParseDepth, но идентификаторы, которые не вызывают функцию,
+// сначала ищутся среди переменных vars (имя сравнивается с учётом регистра),
+// а затем среди констант. Переменная подставляется в дерево своим значением;
+// неизвестное имя – ErrInvalidExpression с сообщением "undefined variable"
+func ParseVars(expression string, vars map[string]float64, maxDepth int) (*Node, error) {
+	tokens, err := Tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &ParseError{Err: ErrInvalidExpression, Message: "empty expression"}
+	}
+
+	p := &parser{tokens: tokens, end: len(expression), maxDepth: maxDepth, vars: vars}
+	node, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		if tok.Kind == RightParenToken {
+			return nil, errorAt(ErrUnbalancedParens, tok, "unexpected %q", tok.Text)
+		}
+		return nil, errorAt(ErrInvalidExpression, tok, "expected operator, got %q", tok.Text)
+	}
+	return node, nil
+}
+
+func (p *parser) peek() (Token, bool) {
+	if p.pos >= len(p.tokens) {
+		return Token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (Token, bool) {
+	tok, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return tok, ok
+}
+
+// parseBinary – разбирает цепочку бинарных операций с приоритетом не ниже minPrec
+func (p *parser) parseBinary(minPrec int) (*Node, error) {
+	if p.depth > p.maxDepth {
+		tok, ok := p.peek()
+		if !ok {
+			tok = Token{Pos: p.end}
+		}
+		return nil, errorAt(ErrTooDeep, tok, "nesting deeper than %d", p.maxDepth)
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.Kind != OperatorToken {
+			return left, nil
+		}
+		prec := precedence(tok.Text)
+		if prec < minPrec {
+			return left, nil
+		}
+		p.pos++
+
+		nextPrec := prec + 1
+		if rightAssociative(tok.Text) {
+			nextPrec = prec
+		}
+		right, err := p.parseBinary(nextPrec)
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Kind: BinaryNode, Op: tok.Text, Left: left, Right: right}
+	}
+}
+
+// parseOperand – разбирает число, переменную, константу, выражение в скобках, вызов функции или операнд с унарным знаком.
+// Унарный знак допустим в начале выражения, после открывающей скобки и после
+// другого оператора, то есть везде, где ожидается операнд
+func (p *parser) parseOperand() (*Node, error) {
+	tok, ok := p.next()
+	if !ok {
+		return nil, p.errorAtEnd(ErrInvalidExpression, "expected operand")
+	}
+	switch tok.Kind {
+	case OperatorToken:
+		if tok.Text != "+" && tok.Text != "-" {
+			return nil, errorAt(ErrInvalidExpression, tok, "expected operand, got %q", tok.Text)
+		}
+		// Унарный знак связывает слабее степени: -2 ^ 2 == -(2 ^ 2)
+		operand, err := p.parseBinary(precedence("^"))
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Kind: UnaryNode, Op: tok.Text, Left: operand}, nil
+	case NumberToken:
+		return &Node{Kind: NumberNode, Value: tok.Value, Text: tok.Text}, nil
+	case LeftParenToken:
+		return p.parseParenthesized()
+	case IdentToken:
+		name := strings.ToLower(tok.Text)
+		if open, ok := p.peek(); !ok || open.Kind != LeftParenToken {
+			if value, found := p.vars[tok.Text]; found {
+				return &Node{Kind: NumberNode, Value: value}, nil
+			}
+			value, found := constants[name]
+			if !found {
+				return nil, errorAt(ErrInvalidExpression, tok, "undefined variable %q", tok.Text)
+			}
+			return &Node{Kind: NumberNode, Value: value}, nil
+		}
+		p.pos++
+		if !isFunction(name) {
+			return nil, errorAt(ErrUnknownFunction, tok, "%q", tok.Text)
+		}
+		arg, err := p.parseParenthesized()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Kind: FuncNode, Op: name, Left: arg}, nil
+	}
+	return nil, errorAt(ErrInvalidExpression, tok, "expected operand, got %q", tok.Text)
+}
+
+// parseParenthesized – разбирает выражение после уже прочитанной открывающей скобки
+func (p *parser) parseParenthesized() (*Node, error) {
+	node, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	closing, ok := p.next()
+	if !ok {
+		return nil, p.errorAtEnd(ErrUnbalancedParens, "missing \")\"")
+	}
+	if closing.Kind != RightParenToken {
+		return nil, errorAt(ErrUnbalancedParens, closing, "expected \")\", got %q", closing.Text)
+	}
+	return node, nil
+}
+
+// errorAtEnd – ошибка разбора в конце выражения
+func (p *parser) errorAtEnd(err error, message string) *ParseError {
+	return errorAt(err, Token{Pos: p.end}, "%s", message)
+}
+
+// precedence – приоритет бинарной операции; сравнения связывают слабее всех
+// и, как остальные операции, кроме степени, левоассоциативны:
+// 1 < 2 < 3 == (1 < 2) < 3
+func precedence(op string) int {
+	switch op {
+	case "<", ">", "<=", ">=", "==", "!=":
+		return 1
+	case "+", "-":
+		return 2
+	case "*", "/", "//", "%":
+		return 3
+	case "^":
+		return 4
+	}
+	return 0
+}
+
+func rightAssociative(op string) bool {
+	return op == "^"
+}
@@ -0,0 +1,118 @@
+package calculation
+
+import "strconv"
+
+// TokenKind – вид лексемы выражения
+type TokenKind int
+
+const (
+	NumberToken TokenKind = iota
+	OperatorToken
+	LeftParenToken
+	RightParenToken
+	IdentToken
+)
+
+// Token – лексема выражения с её позицией (байтовым смещением) во входной строке
+type Token struct {
+	Kind  TokenKind
+	Text  string
+	Value float64
+	Pos   int
+}
+
+// Tokenize – разбивает строку на числа, операторы и скобки независимо от пробелов
+func Tokenize(expression string) ([]Token, error) {
+	var tokens []Token
+	for i := 0; i < len(expression); {
+		char := expression[i]
+		switch {
+		case isSpace(char):
+			i++
+		case isDigit(char) || char == '.':
+			token, next, err := searchnumbers(expression, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+			i = next
+		case isLetter(char):
+			start := i
+			for i < len(expression) && (isLetter(expression[i]) || isDigit(expression[i])) {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: IdentToken, Text: expression[start:i], Pos: start})
+		case char == '(':
+			tokens = append(tokens, Token{Kind: LeftParenToken, Text: "(", Pos: i})
+			i++
+		case char == ')':
+			tokens = append(tokens, Token{Kind: RightParenToken, Text: ")", Pos: i})
+			i++
+		case char == '/' && i+1 < len(expression) && expression[i+1] == '/':
+			// Целочисленное деление
+			tokens = append(tokens, Token{Kind: OperatorToken, Text: "//", Pos: i})
+			i += 2
+		case isOperator(char):
+			tokens = append(tokens, Token{Kind: OperatorToken, Text: string(char), Pos: i})
+			i++
+		case char == '<' || char == '>' || char == '=' || char == '!':
+			// Сравнения: "<" и ">" бывают и без "=", "=" и "!" – только с ним
+			if i+1 < len(expression) && expression[i+1] == '=' {
+				tokens = append(tokens, Token{Kind: OperatorToken, Text: expression[i : i+2], Pos: i})
+				i += 2
+			} else if char == '<' || char == '>' {
+				tokens = append(tokens, Token{Kind: OperatorToken, Text: string(char), Pos: i})
+				i++
+			} else {
+				return nil, errorAt(ErrInvalidExpression, Token{Text: string(char), Pos: i}, "unexpected character %q", char)
+			}
+		default:
+			return nil, errorAt(ErrInvalidExpression, Token{Text: string(char), Pos: i}, "unexpected character %q", char)
+		}
+	}
+	return tokens, nil
+}
+
+// searchnumbers – число с позиции index, в том числе в научной нотации
+// ("1.5e-3", "2E10"). Экспонента – часть числа, только если за "e" (и знаком)
+// идёт цифра, иначе "e" – отдельная лексема (константа e)
+func searchnumbers(expression string, index int) (Token, int, error) {
+	start := index
+	for index < len(expression) && (isDigit(expression[index]) || expression[index] == '.') {
+		index++
+	}
+	if index < len(expression) && (expression[index] == 'e' || expression[index] == 'E') {
+		next := index + 1
+		if next < len(expression) && (expression[next] == '+' || expression[next] == '-') {
+			next++
+		}
+		if next < len(expression) && isDigit(expression[next]) {
+			index = next
+			for index < len(expression) && isDigit(expression[index]) {
+				index++
+			}
+		}
+	}
+	text := expression[start:index]
+	val, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Token{}, index, errorAt(ErrInvalidExpression, Token{Text: text, Pos: start}, "invalid number %q", text)
+	}
+	return Token{Kind: NumberToken, Text: text, Value: val, Pos: start}, index, nil
+}
+
+func isSpace(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\n' || char == '\r'
+}
+
+func isDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
+
+func isLetter(char byte) bool {
+	return char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char == '_'
+}
+
+func isOperator(char byte) bool {
+	return char == '+' || char == '-' || char == '*' || char == '/' || char == '%' || char == '^'
+}
@@ -0,0 +1,175 @@
+// Package client – Go-клиент HTTP API оркестратора
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request – тело запроса на вычисление выражения
+type Request struct {
+	Expression  string             `json:"expression"`
+	CallbackURL string             `json:"callback_url,omitempty"`
+	Priority    int                `json:"priority,omitempty"`
+	Vars        map[string]float64 `json:"vars,omitempty"`
+}
+
+// Expression – выражение и его состояние в том виде, в каком их отдаёт
+// /api/v2. Result – nil, пока выражение не посчитано
+type Expression struct {
+	ID          string             `json:"id"`
+	Expression  string             `json:"expression"`
+	Vars        map[string]float64 `json:"vars,omitempty"`
+	Status      string             `json:"status"`
+	Result      *float64           `json:"result"`
+	Error       string             `json:"error,omitempty"`
+	Progress    Progress           `json:"progress"`
+	Priority    int                `json:"priority"`
+	CallbackURL string             `json:"callback_url,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// Progress – прогресс выражения: посчитанные задачи из общего числа и доля в процентах
+type Progress struct {
+	CompletedTasks int     `json:"completed_tasks"`
+	TotalTasks     int     `json:"total_tasks"`
+	Percent        float64 `json:"percent"`
+}
+
+// Client – клиент /api/v2 оркестратора: в /api/v1 у выражения нет ошибки,
+// прогресса и времени
+type Client struct {
+	baseURL string
+	http    *http.Client
+	// APIKey – ключ для заголовка Authorization, если API защищено
+	APIKey string
+}
+
+// New – клиент оркестратора по адресу baseURL (например, "http://localhost:8080");
+// timeout ограничивает каждый запрос, 0 – без ограничения
+func New(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+// APIError – ответ оркестратора с кодом ошибки
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("orchestrator returned %d: %s", e.StatusCode, e.Message)
+}
+
+// ListOptions – фильтр и страница списка выражений; нулевые поля не передаются
+type ListOptions struct {
+	Status string
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// ExpressionList – страница списка выражений
+type ExpressionList struct {
+	Expressions []Expression `json:"expressions"`
+	Total       int          `json:"total"`
+	Limit       int          `json:"limit"`
+	Offset      int          `json:"offset"`
+}
+
+// Calculate – отправка выражения на вычисление; возвращает ID выражения
+func (c *Client) Calculate(ctx context.Context, expr string) (string, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/v2/calculate", Request{Expression: expr}, &created)
+	return created.ID, err
+}
+
+// GetExpression – выражение по ID
+func (c *Client) GetExpression(ctx context.Context, id string) (Expression, error) {
+	var expr Expression
+	err := c.do(ctx, http.MethodGet, "/api/v2/expressions/"+url.PathEscape(id), nil, &expr)
+	return expr, err
+}
+
+// ListExpressions – страница списка выражений
+func (c *Client) ListExpressions(ctx context.Context, opts ListOptions) (ExpressionList, error) {
+	query := url.Values{}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	path := "/api/v2/expressions"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var list ExpressionList
+	err := c.do(ctx, http.MethodGet, path, nil, &list)
+	return list, err
+}
+
+// do – запрос к оркестратору с JSON-телом in и разбором ответа в out.
+// Ответ не 2xx возвращается как *APIError
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var payload struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&payload) == nil {
+			apiErr.Message = payload.Error
+		}
+		return apiErr
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response body: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Powdersumm/Yandexlmscalcproject2sprint/pkg/client"
+)
+
+func TestClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v2/calculate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"abc"}`))
+	})
+	mux.HandleFunc("GET /api/v2/expressions/abc", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"abc","expression":"2+2","status":"completed","result":4,"progress":{"completed_tasks":1,"total_tasks":1,"percent":100}}`))
+	})
+	mux.HandleFunc("GET /api/v2/expressions/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"expression not found"}`))
+	})
+	mux.HandleFunc("GET /api/v2/expressions", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.RawQuery; got != "limit=1&status=completed" {
+			t.Errorf("unexpected query %q", got)
+		}
+		w.Write([]byte(`{"expressions":[{"id":"abc","status":"completed","result":4,"progress":{"completed_tasks":1,"total_tasks":1,"percent":100}}],"total":3,"limit":1,"offset":0}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := client.New(srv.URL+"/", time.Second)
+	c.APIKey = "secret"
+	ctx := context.Background()
+
+	id, err := c.Calculate(ctx, "2+2")
+	if err != nil || id != "abc" {
+		t.Fatalf("Calculate: got %q, %v", id, err)
+	}
+
+	expr, err := c.GetExpression(ctx, id)
+	if err != nil || expr.Status != "completed" || expr.Result == nil || *expr.Result != 4 || expr.Progress.Percent != 100 {
+		t.Fatalf("GetExpression: got %+v, %v", expr, err)
+	}
+
+	_, err = c.GetExpression(ctx, "missing")
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "expression not found" {
+		t.Fatalf("GetExpression(missing): expected 404 APIError, got %v", err)
+	}
+
+	list, err := c.ListExpressions(ctx, client.ListOptions{Status: "completed", Limit: 1})
+	if err != nil || list.Total != 3 || len(list.Expressions) != 1 || list.Expressions[0].ID != "abc" || list.Expressions[0].Progress.TotalTasks != 1 {
+		t.Fatalf("ListExpressions: got %+v, %v", list, err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	if _, err := client.New(srv.URL, 50*time.Millisecond).GetExpression(context.Background(), "abc"); err == nil {
+		t.Fatal("expected timeout error")
+	}
+}